@@ -45,6 +45,19 @@ func NewGmailAuth(ctx context.Context, cfg *config.Config) (*GmailAuth, error) {
 	}, nil
 }
 
+// NewGmailAuthWithOptions builds the Gmail service from explicit client
+// options instead of the OAuth flow, e.g. to point it at a fake server.
+func NewGmailAuthWithOptions(ctx context.Context, opts ...option.ClientOption) (*GmailAuth, error) {
+	srv, err := gmail.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Gmail service: %v", err)
+	}
+
+	return &GmailAuth{
+		Service: srv,
+	}, nil
+}
+
 func getClient(config *oauth2.Config, profile string) *http.Client {
 	tokFile := fmt.Sprintf("%s/.config/clean_newsletters/%s/token.json", os.Getenv("HOME"), profile)
 	tok, err := tokenFromFile(tokFile)
@@ -0,0 +1,116 @@
+package bayes
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"clean_newsletters/internal/atomicfile"
+)
+
+// Model is a multinomial Naive Bayes classifier with two classes,
+// newsletter and not newsletter, using add-one smoothing.
+type Model struct {
+	TrainedAt  time.Time `json:"trained_at"`
+	Examples   int       `json:"examples"`
+	Newsletter Class     `json:"newsletter"`
+	Other      Class     `json:"other"`
+	// Vocabulary is the number of distinct features seen in training.
+	Vocabulary int `json:"vocabulary"`
+}
+
+// Class holds the training counts for one class.
+type Class struct {
+	Documents int            `json:"documents"`
+	Total     int            `json:"total"`
+	Counts    map[string]int `json:"counts"`
+}
+
+// Train builds a model from examples. Later examples for the same message
+// replace earlier ones, so user corrections override classifier decisions.
+func Train(examples []Example) (*Model, error) {
+	latest := make(map[string]Example, len(examples))
+	var order []string
+	for _, example := range examples {
+		if _, seen := latest[example.MessageID]; !seen {
+			order = append(order, example.MessageID)
+		}
+		latest[example.MessageID] = example
+	}
+
+	m := &Model{
+		TrainedAt:  time.Now(),
+		Newsletter: Class{Counts: make(map[string]int)},
+		Other:      Class{Counts: make(map[string]int)},
+	}
+	vocabulary := make(map[string]bool)
+	for _, id := range order {
+		example := latest[id]
+		class := &m.Other
+		if example.Newsletter {
+			class = &m.Newsletter
+		}
+		class.Documents++
+		for _, feature := range example.Features {
+			class.Counts[feature]++
+			class.Total++
+			vocabulary[feature] = true
+		}
+	}
+	m.Examples = len(order)
+	m.Vocabulary = len(vocabulary)
+
+	if m.Newsletter.Documents == 0 || m.Other.Documents == 0 {
+		return nil, fmt.Errorf("need examples of both newsletters and other mail, have %d and %d", m.Newsletter.Documents, m.Other.Documents)
+	}
+
+	return m, nil
+}
+
+// Predict returns the probability that an email with these features is a
+// newsletter.
+func (m *Model) Predict(features []string) float64 {
+	documents := float64(m.Newsletter.Documents + m.Other.Documents)
+	newsletter := math.Log(float64(m.Newsletter.Documents) / documents)
+	other := math.Log(float64(m.Other.Documents) / documents)
+
+	for _, feature := range features {
+		newsletter += m.Newsletter.logLikelihood(feature, m.Vocabulary)
+		other += m.Other.logLikelihood(feature, m.Vocabulary)
+	}
+
+	// Normalise in log space to avoid underflow on long emails.
+	return 1 / (1 + math.Exp(other-newsletter))
+}
+
+func (c *Class) logLikelihood(feature string, vocabulary int) float64 {
+	return math.Log(float64(c.Counts[feature]+1) / float64(c.Total+vocabulary+1))
+}
+
+// Load reads a model saved by Save. A missing file returns a nil model and
+// no error, since no model has been trained yet.
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read classifier model: %v", err)
+	}
+
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unable to parse classifier model %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+func (m *Model) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(path, data)
+}
@@ -0,0 +1,64 @@
+package bayes
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func example(id string, newsletter bool, features ...string) Example {
+	return Example{MessageID: id, Newsletter: newsletter, Features: features}
+}
+
+func TestTrainAndPredict(t *testing.T) {
+	examples := []Example{
+		example("1", true, "h:list-unsubscribe", "d:news.example", "s:weekly", "b:unsubscribe"),
+		example("2", true, "h:list-id", "d:news.example", "s:digest", "b:unsubscribe"),
+		example("3", true, "h:list-unsubscribe", "d:shop.example", "s:sale", "b:offer"),
+		example("4", false, "d:example.com", "s:lunch", "b:tomorrow"),
+		example("5", false, "d:example.com", "s:meeting", "b:agenda"),
+		// A later answer for the same message replaces the earlier one
+		example("6", true, "d:example.com", "s:invoice"),
+		example("6", false, "d:example.com", "s:invoice"),
+	}
+
+	m, err := Train(examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Examples != 6 || m.Newsletter.Documents != 3 || m.Other.Documents != 3 {
+		t.Errorf("model has %d examples, %d newsletters and %d other", m.Examples, m.Newsletter.Documents, m.Other.Documents)
+	}
+
+	if p := m.Predict([]string{"h:list-unsubscribe", "d:news.example", "b:unsubscribe"}); p < 0.9 {
+		t.Errorf("newsletter features predicted %.3f", p)
+	}
+	if p := m.Predict([]string{"d:example.com", "s:lunch"}); p > 0.1 {
+		t.Errorf("personal features predicted %.3f", p)
+	}
+	if p := m.Predict([]string{"s:unseen"}); p < 0.4 || p > 0.6 {
+		t.Errorf("unseen feature predicted %.3f, want about 0.5", p)
+	}
+
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := []string{"h:list-id", "s:digest"}
+	if loaded.Predict(features) != m.Predict(features) {
+		t.Errorf("loaded model predicts %.3f, saved one %.3f", loaded.Predict(features), m.Predict(features))
+	}
+
+	if missing, err := Load(filepath.Join(t.TempDir(), "missing.json")); missing != nil || err != nil {
+		t.Errorf("Load of a missing model = %v, %v", missing, err)
+	}
+}
+
+func TestTrainNeedsBothClasses(t *testing.T) {
+	if _, err := Train([]Example{example("1", true, "s:weekly")}); err == nil {
+		t.Errorf("trained without any other mail")
+	}
+}
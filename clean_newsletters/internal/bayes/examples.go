@@ -0,0 +1,171 @@
+package bayes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"clean_newsletters/internal/email"
+)
+
+// bodyFeatureChars limits how much of the body is tokenized.
+const bodyFeatureChars = 2000
+
+// Example is one labeled email, reduced to its features so the example log
+// never stores message text.
+type Example struct {
+	MessageID  string    `json:"message_id"`
+	Newsletter bool      `json:"newsletter"`
+	Source     string    `json:"source"`
+	Features   []string  `json:"features"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Features extracts the tokens the model is trained on: which list and bulk
+// headers are present, the sender's domain, and the words of the subject
+// and the start of the body, each prefixed by where it came from.
+func Features(e *email.Email) []string {
+	var features []string
+	for name, value := range e.Headers {
+		switch name = strings.ToLower(name); name {
+		case "list-unsubscribe", "list-id", "list-unsubscribe-post", "feedback-id", "x-campaign", "x-mailer":
+			features = append(features, "h:"+name)
+		case "precedence", "auto-submitted":
+			features = append(features, "h:"+name+"="+strings.ToLower(strings.TrimSpace(value)))
+		}
+	}
+
+	address := strings.ToLower(e.FromAddress)
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		features = append(features, "d:"+address[at+1:])
+	}
+
+	features = append(features, words("s:", e.Subject)...)
+	body := e.Body
+	if len(body) > bodyFeatureChars {
+		body = body[:bodyFeatureChars]
+	}
+	features = append(features, words("b:", body)...)
+
+	return features
+}
+
+func words(prefix, text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if len(word) >= 3 && len(word) <= 20 {
+			tokens = append(tokens, prefix+word)
+		}
+	}
+	return tokens
+}
+
+// ExampleLog appends training examples to a JSONL file. A nil log discards
+// everything, which is how evaluation runs avoid training on their dataset.
+type ExampleLog struct {
+	mu   sync.Mutex
+	path string
+	// logged holds the latest answer per message ID, read from the file on
+	// the first Add.
+	logged map[string]bool
+}
+
+func NewExampleLog(path string) *ExampleLog {
+	return &ExampleLog{path: path}
+}
+
+// Add appends an example unless the message is already logged with the
+// same answer, so emails left in the inbox are not recorded on every run
+// while a changed answer, such as a review correction, still is.
+func (l *ExampleLog) Add(e *email.Email, newsletter bool, source string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.logged == nil {
+		examples, err := ReadExamples(l.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		l.logged = make(map[string]bool, len(examples))
+		for _, example := range examples {
+			l.logged[example.MessageID] = example.Newsletter
+		}
+	}
+
+	id := messageID(e)
+	if logged, ok := l.logged[id]; ok && logged == newsletter {
+		return nil
+	}
+
+	data, err := json.Marshal(Example{
+		MessageID:  id,
+		Newsletter: newsletter,
+		Source:     source,
+		Features:   Features(e),
+		RecordedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open example log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("unable to write example: %v", err)
+	}
+	l.logged[id] = newsletter
+	return nil
+}
+
+// messageID prefers the Message-Id header, which unlike provider IDs stays
+// the same across mailboxes and archive exports.
+func messageID(e *email.Email) string {
+	if value := e.Header("Message-Id"); value != "" {
+		return value
+	}
+	return e.ID
+}
+
+// ReadExamples loads every example from a log written by ExampleLog.
+func ReadExamples(path string) ([]Example, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open example log: %w", err)
+	}
+	defer f.Close()
+
+	var examples []Example
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var example Example
+		if err := json.Unmarshal(scanner.Bytes(), &example); err != nil {
+			return nil, fmt.Errorf("invalid example on line %d of %s: %v", line, path, err)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read example log: %v", err)
+	}
+
+	return examples, nil
+}
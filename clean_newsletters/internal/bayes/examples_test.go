@@ -0,0 +1,49 @@
+package bayes
+
+import (
+	"path/filepath"
+	"testing"
+
+	"clean_newsletters/internal/email"
+)
+
+func TestExampleLogSkipsLoggedMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "examples.jsonl")
+	e := &email.Email{
+		ID:      "provider-id",
+		Subject: "Weekly digest",
+		Headers: map[string]string{"Message-Id": "<1@news.example>", "List-Id": "<weekly.news.example>"},
+	}
+
+	l := NewExampleLog(path)
+	for i := 0; i < 3; i++ {
+		if err := l.Add(e, true, "llm"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A fresh log, as on the next run, reads what is already recorded
+	l = NewExampleLog(path)
+	if err := l.Add(e, true, "llm"); err != nil {
+		t.Fatal(err)
+	}
+	// A changed answer is always recorded
+	if err := l.Add(e, false, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	examples, err := ReadExamples(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(examples) != 2 {
+		t.Fatalf("logged %d examples, want 2", len(examples))
+	}
+	if examples[0].MessageID != "<1@news.example>" || !examples[0].Newsletter || examples[1].Newsletter || examples[1].Source != "user" {
+		t.Errorf("examples = %+v", examples)
+	}
+
+	var discard *ExampleLog
+	if err := discard.Add(e, true, "llm"); err != nil {
+		t.Errorf("nil log: %v", err)
+	}
+}
@@ -2,8 +2,21 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"clean_newsletters/internal/llm"
+)
+
+const (
+	ProviderGmail   = "gmail"
+	ProviderIMAP    = "imap"
+	ProviderMbox    = "mbox"
+	ProviderMaildir = "maildir"
 )
 
 type Config struct {
@@ -12,44 +25,446 @@ type Config struct {
 	SubscribedEmails   []string
 	AccountProfile     string
 	OpenRouterModel    string
+	LLMBaseURL         string
+
+	LLMTimeout           time.Duration
+	LLMMaxRetries        int
+	LLMRequestsPerMinute int
+	LLMResponseFormat    string
+	LLMBatchSize         int
+	LLMContextWindow     int
+	LLMPriceTable        string
+	LLMBudgetUSD         float64
+	LLMCassette          string
+	LLMCassetteMode      string
+
+	MailProvider  string
+	IMAPAddress   string
+	IMAPUsername  string
+	IMAPPassword  string
+	IMAPMailbox   string
+	IMAPLabelMode string
+	IMAPTLS       bool
+
+	ArchivePath string
+	ReportPath  string
+
+	ReviewThreshold float64
+
+	TrackerStore string
+
+	EmbeddingsURL    string
+	EmbeddingsAPIKey string
+	EmbeddingsModel  string
+	MatchTopK        int
+	MatchThreshold   float64
+
+	BayesThreshold float64
+
+	ReevaluateAfter       time.Duration
+	ReevaluateMinReadRate float64
+	ReevaluateMinMessages int
+	ReevaluateMaxPerWeek  float64
+	ReevaluateOnRun       bool
+
+	ReportFormat string
+	ReportFile   string
+
+	DigestTo    string
+	ReadingList bool
 }
 
 func Load() (*Config, error) {
+	cfg, err := LoadClassifier()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.CheckAPIKey(); err != nil {
+		return nil, err
+	}
+
+	cfg.MailProvider = strings.ToLower(os.Getenv("MAIL_PROVIDER"))
+	if cfg.MailProvider == "" {
+		cfg.MailProvider = ProviderGmail
+	}
+
+	switch cfg.MailProvider {
+	case ProviderGmail:
+		if err := loadGmail(cfg); err != nil {
+			return nil, err
+		}
+	case ProviderIMAP:
+		if err := loadIMAP(cfg); err != nil {
+			return nil, err
+		}
+	case ProviderMbox, ProviderMaildir:
+		if err := loadArchive(cfg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_PROVIDER %q (expected %s, %s, %s or %s)", cfg.MailProvider, ProviderGmail, ProviderIMAP, ProviderMbox, ProviderMaildir)
+	}
+
+	// How the end-of-run report is printed
+	cfg.ReportFormat = strings.ToLower(os.Getenv("REPORT_FORMAT"))
+	switch cfg.ReportFormat {
+	case "":
+		cfg.ReportFormat = "text"
+	case "text", "json", "markdown":
+	default:
+		return nil, fmt.Errorf("REPORT_FORMAT must be text, json or markdown, got %q", cfg.ReportFormat)
+	}
+	cfg.ReportFile = os.Getenv("REPORT_FILE")
+
+	// Digests are only sent when there is somewhere to send them
+	cfg.DigestTo = os.Getenv("DIGEST_TO")
+	cfg.ReadingList = os.Getenv("READING_LIST") == "true"
+
+	return cfg, nil
+}
+
+// LoadTracker loads only the profile and tracker settings, for commands
+// such as history that read the tracker without classifying anything.
+func LoadTracker() (*Config, error) {
 	cfg := &Config{}
+	if err := loadProfile(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	cfg.OpenRouterAPIKey = os.Getenv("OPENROUTER_API_KEY")
-	if cfg.OpenRouterAPIKey == "" {
-		return nil, fmt.Errorf("OPENROUTER_API_KEY environment variable is required")
+// LoadClassifier loads only the LLM, profile and classification settings,
+// for commands such as eval that never open a mailbox. The API key is not
+// checked because replayed runs make no LLM requests; call CheckAPIKey
+// before talking to a live endpoint.
+func LoadClassifier() (*Config, error) {
+	cfg := &Config{}
+
+	// Any OpenAI-compatible endpoint works; local servers usually need no key.
+	cfg.LLMBaseURL = os.Getenv("LLM_BASE_URL")
+	if cfg.LLMBaseURL == "" {
+		cfg.LLMBaseURL = llm.DefaultBaseURL
 	}
 
+	cfg.OpenRouterAPIKey = os.Getenv("OPENROUTER_API_KEY")
+
 	cfg.OpenRouterModel = os.Getenv("OPENROUTER_MODEL")
 	if cfg.OpenRouterModel == "" {
 		cfg.OpenRouterModel = "meta-llama/llama-3.3-70b-instruct:groq" // Default model with Groq provider
 	}
 
+	if err := loadLLMLimits(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := loadProfile(cfg); err != nil {
+		return nil, err
+	}
+
+	// Decisions below this confidence are labeled for manual review
+	cfg.ReviewThreshold = 0.6
+	if value := os.Getenv("REVIEW_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("invalid REVIEW_THRESHOLD %q (expected a number between 0 and 1)", value)
+		}
+		cfg.ReviewThreshold = threshold
+	}
+
+	if err := loadMatching(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := loadReevaluation(cfg); err != nil {
+		return nil, err
+	}
+
+	subscribedList := os.Getenv("SUBSCRIBED_NEWSLETTERS")
+	if subscribedList != "" {
+		cfg.SubscribedEmails = strings.Split(subscribedList, ",")
+		for i := range cfg.SubscribedEmails {
+			cfg.SubscribedEmails[i] = strings.TrimSpace(cfg.SubscribedEmails[i])
+		}
+	}
+
+	return cfg, nil
+}
+
+// CheckAPIKey reports a missing key for endpoints that always need one.
+// Strict cassette replays never reach the endpoint and need no key.
+func (c *Config) CheckAPIKey() error {
+	if c.LLMCassette != "" && c.LLMCassetteMode == llm.CassetteStrict {
+		return nil
+	}
+	if c.OpenRouterAPIKey != "" {
+		return nil
+	}
+
+	endpoint, err := url.Parse(c.LLMBaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid LLM_BASE_URL %q: %v", c.LLMBaseURL, err)
+	}
+	host := strings.ToLower(endpoint.Hostname())
+	if host == "openrouter.ai" || strings.HasSuffix(host, ".openrouter.ai") {
+		return fmt.Errorf("OPENROUTER_API_KEY environment variable is required")
+	}
+	return nil
+}
+
+// ProfileDir is where per-profile state (tokens, tracker, usage) is stored.
+func (c *Config) ProfileDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "clean_newsletters", c.AccountProfile)
+}
+
+func loadProfile(cfg *Config) error {
 	// Get account profile (defaults to "default")
 	cfg.AccountProfile = os.Getenv("GMAIL_ACCOUNT_PROFILE")
 	if cfg.AccountProfile == "" {
 		cfg.AccountProfile = "default"
 	}
 
+	cfg.TrackerStore = strings.ToLower(os.Getenv("TRACKER_STORE"))
+	switch cfg.TrackerStore {
+	case "":
+		cfg.TrackerStore = "json"
+	case "json", "sqlite":
+	default:
+		return fmt.Errorf("TRACKER_STORE must be json or sqlite, got %q", cfg.TrackerStore)
+	}
+	return nil
+}
+
+func loadLLMLimits(cfg *Config) error {
+	cfg.LLMTimeout = 60 * time.Second
+	if value := os.Getenv("LLM_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid LLM_TIMEOUT %q (expected a duration like 30s): %v", value, err)
+		}
+		cfg.LLMTimeout = timeout
+	}
+
+	cfg.LLMMaxRetries = 3
+	if value := os.Getenv("LLM_MAX_RETRIES"); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("invalid LLM_MAX_RETRIES %q (expected a non-negative integer)", value)
+		}
+		cfg.LLMMaxRetries = retries
+	}
+
+	if value := os.Getenv("LLM_REQUESTS_PER_MINUTE"); value != "" {
+		rpm, err := strconv.Atoi(value)
+		if err != nil || rpm < 0 {
+			return fmt.Errorf("invalid LLM_REQUESTS_PER_MINUTE %q (expected a non-negative integer)", value)
+		}
+		cfg.LLMRequestsPerMinute = rpm
+	}
+
+	cfg.LLMBatchSize = 10
+	if value := os.Getenv("LLM_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return fmt.Errorf("invalid LLM_BATCH_SIZE %q (expected a positive integer)", value)
+		}
+		cfg.LLMBatchSize = size
+	}
+
+	// Zero asks the LLM endpoint for the model's window
+	cfg.LLMContextWindow = 0
+	if value := os.Getenv("LLM_CONTEXT_WINDOW"); value != "" {
+		window, err := strconv.Atoi(value)
+		if err != nil || window < 1024 {
+			return fmt.Errorf("invalid LLM_CONTEXT_WINDOW %q (expected a token count of at least 1024)", value)
+		}
+		cfg.LLMContextWindow = window
+	}
+
+	cfg.LLMPriceTable = os.Getenv("LLM_PRICE_TABLE")
+
+	if value := os.Getenv("LLM_BUDGET_USD"); value != "" {
+		budget, err := strconv.ParseFloat(value, 64)
+		if err != nil || budget < 0 {
+			return fmt.Errorf("invalid LLM_BUDGET_USD %q (expected a dollar amount like 0.50)", value)
+		}
+		cfg.LLMBudgetUSD = budget
+	}
+
+	// Record or replay LLM requests for offline, repeatable runs
+	cfg.LLMCassette = os.Getenv("LLM_CASSETTE")
+	cfg.LLMCassetteMode = strings.ToLower(os.Getenv("LLM_CASSETTE_MODE"))
+	switch cfg.LLMCassetteMode {
+	case "":
+		cfg.LLMCassetteMode = "replay"
+	case "record", "replay", "strict":
+	default:
+		return fmt.Errorf("LLM_CASSETTE_MODE must be record, replay or strict, got %q", cfg.LLMCassetteMode)
+	}
+
+	cfg.LLMResponseFormat = strings.ToLower(os.Getenv("LLM_RESPONSE_FORMAT"))
+	switch cfg.LLMResponseFormat {
+	case "":
+		cfg.LLMResponseFormat = "json_schema"
+	case "json_schema", "json_object", "none":
+	default:
+		return fmt.Errorf("LLM_RESPONSE_FORMAT must be json_schema, json_object or none, got %q", cfg.LLMResponseFormat)
+	}
+
+	return nil
+}
+
+// loadMatching configures the tiers that can decide an email before the LLM:
+// matching against subscribed senders and the local classifier. Without
+// EMBEDDINGS_URL the built-in lexical embedder is used.
+func loadMatching(cfg *Config) error {
+	cfg.EmbeddingsURL = os.Getenv("EMBEDDINGS_URL")
+	cfg.EmbeddingsAPIKey = os.Getenv("EMBEDDINGS_API_KEY")
+	if cfg.EmbeddingsAPIKey == "" && cfg.EmbeddingsURL == cfg.LLMBaseURL {
+		cfg.EmbeddingsAPIKey = cfg.OpenRouterAPIKey
+	}
+	cfg.EmbeddingsModel = os.Getenv("EMBEDDINGS_MODEL")
+	if cfg.EmbeddingsModel == "" {
+		cfg.EmbeddingsModel = "text-embedding-3-small"
+	}
+
+	cfg.MatchTopK = 5
+	if value := os.Getenv("MATCH_TOP_K"); value != "" {
+		k, err := strconv.Atoi(value)
+		if err != nil || k < 1 {
+			return fmt.Errorf("invalid MATCH_TOP_K %q (expected a positive integer)", value)
+		}
+		cfg.MatchTopK = k
+	}
+
+	// Matches at least this similar skip the LLM entirely
+	cfg.MatchThreshold = 0.9
+	if value := os.Getenv("MATCH_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return fmt.Errorf("invalid MATCH_THRESHOLD %q (expected a number between 0 and 1)", value)
+		}
+		cfg.MatchThreshold = threshold
+	}
+
+	// The local classifier only answers when at least this sure
+	cfg.BayesThreshold = 0.98
+	if value := os.Getenv("BAYES_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return fmt.Errorf("invalid BAYES_THRESHOLD %q (expected a number between 0 and 1)", value)
+		}
+		cfg.BayesThreshold = threshold
+	}
+
+	return nil
+}
+
+// loadReevaluation configures when decided senders are questioned again:
+// once their decision is old, or when the user rarely opens or is flooded
+// by a subscribed newsletter. Zero disables each rule.
+func loadReevaluation(cfg *Config) error {
+	cfg.ReevaluateAfter = 365 * 24 * time.Hour
+	if value := os.Getenv("REEVALUATE_AFTER_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return fmt.Errorf("invalid REEVALUATE_AFTER_DAYS %q (expected a number of days)", value)
+		}
+		cfg.ReevaluateAfter = time.Duration(days) * 24 * time.Hour
+	}
+
+	cfg.ReevaluateMinReadRate = 0.1
+	if value := os.Getenv("REEVALUATE_MIN_READ_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("invalid REEVALUATE_MIN_READ_RATE %q (expected a number between 0 and 1)", value)
+		}
+		cfg.ReevaluateMinReadRate = rate
+	}
+
+	// Read rates over fewer messages than this say too little
+	cfg.ReevaluateMinMessages = 5
+	if value := os.Getenv("REEVALUATE_MIN_MESSAGES"); value != "" {
+		messages, err := strconv.Atoi(value)
+		if err != nil || messages < 1 {
+			return fmt.Errorf("invalid REEVALUATE_MIN_MESSAGES %q (expected a positive integer)", value)
+		}
+		cfg.ReevaluateMinMessages = messages
+	}
+
+	if value := os.Getenv("REEVALUATE_MAX_PER_WEEK"); value != "" {
+		perWeek, err := strconv.ParseFloat(value, 64)
+		if err != nil || perWeek < 0 {
+			return fmt.Errorf("invalid REEVALUATE_MAX_PER_WEEK %q (expected a non-negative number)", value)
+		}
+		cfg.ReevaluateMaxPerWeek = perWeek
+	}
+
+	cfg.ReevaluateOnRun = os.Getenv("REEVALUATE_ON_RUN") == "true"
+
+	return nil
+}
+
+func loadGmail(cfg *Config) error {
 	cfg.GoogleCredentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
 	if cfg.GoogleCredentials == "" {
 		credentialsPath := fmt.Sprintf("%s/.config/clean_newsletters/%s/credentials.json", os.Getenv("HOME"), cfg.AccountProfile)
 		if _, err := os.Stat(credentialsPath); err == nil {
 			cfg.GoogleCredentials = credentialsPath
 		} else {
-			return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS environment variable is required or place credentials at %s", credentialsPath)
+			return fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS environment variable is required or place credentials at %s", credentialsPath)
 		}
 	}
+	return nil
+}
 
-	subscribedList := os.Getenv("SUBSCRIBED_NEWSLETTERS")
-	if subscribedList != "" {
-		cfg.SubscribedEmails = strings.Split(subscribedList, ",")
-		for i := range cfg.SubscribedEmails {
-			cfg.SubscribedEmails[i] = strings.TrimSpace(cfg.SubscribedEmails[i])
-		}
+func loadIMAP(cfg *Config) error {
+	cfg.IMAPAddress = os.Getenv("IMAP_ADDRESS")
+	if cfg.IMAPAddress == "" {
+		return fmt.Errorf("IMAP_ADDRESS environment variable is required when MAIL_PROVIDER=imap (e.g. imap.fastmail.com:993)")
 	}
 
-	return cfg, nil
-}
\ No newline at end of file
+	cfg.IMAPUsername = os.Getenv("IMAP_USERNAME")
+	cfg.IMAPPassword = os.Getenv("IMAP_PASSWORD")
+	if cfg.IMAPUsername == "" || cfg.IMAPPassword == "" {
+		return fmt.Errorf("IMAP_USERNAME and IMAP_PASSWORD environment variables are required when MAIL_PROVIDER=imap")
+	}
+
+	cfg.IMAPMailbox = os.Getenv("IMAP_MAILBOX")
+	if cfg.IMAPMailbox == "" {
+		cfg.IMAPMailbox = "INBOX"
+	}
+
+	cfg.IMAPLabelMode = strings.ToLower(os.Getenv("IMAP_LABEL_MODE"))
+	if cfg.IMAPLabelMode == "" {
+		cfg.IMAPLabelMode = "folder"
+	}
+	if cfg.IMAPLabelMode != "folder" && cfg.IMAPLabelMode != "keyword" {
+		return fmt.Errorf("IMAP_LABEL_MODE must be folder or keyword, got %q", cfg.IMAPLabelMode)
+	}
+
+	// TLS is on by default; disable it only for local or test servers.
+	cfg.IMAPTLS = os.Getenv("IMAP_TLS") != "false"
+
+	return nil
+}
+
+// IsArchive reports whether the mail provider reads an exported archive
+// rather than a live mailbox.
+func (c *Config) IsArchive() bool {
+	return c.MailProvider == ProviderMbox || c.MailProvider == ProviderMaildir
+}
+
+func loadArchive(cfg *Config) error {
+	cfg.ArchivePath = os.Getenv("ARCHIVE_PATH")
+	if cfg.ArchivePath == "" {
+		return fmt.Errorf("ARCHIVE_PATH environment variable is required when MAIL_PROVIDER=%s", cfg.MailProvider)
+	}
+
+	cfg.ReportPath = os.Getenv("REPORT_PATH")
+	if cfg.ReportPath == "" {
+		cfg.ReportPath = "decisions.csv"
+	}
+
+	return nil
+}
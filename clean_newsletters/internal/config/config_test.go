@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+
+	"clean_newsletters/internal/llm"
+)
+
+func TestCheckAPIKey(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		key     string
+		wantErr bool
+	}{
+		{llm.DefaultBaseURL, "", true},
+		{"https://eu.openrouter.ai/api/v1", "", true},
+		{llm.DefaultBaseURL, "sk-test", false},
+		{"http://localhost:11434/v1", "", false},
+		// Only the host decides, not the rest of the URL
+		{"http://localhost:8080/openrouter.ai/v1", "", false},
+		{"https://openrouter.ai.example.com/v1", "", false},
+		{"http://[::1", "", true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{LLMBaseURL: tt.baseURL, OpenRouterAPIKey: tt.key}
+		if err := cfg.CheckAPIKey(); (err != nil) != tt.wantErr {
+			t.Errorf("CheckAPIKey(%q, key %q) = %v, want error %t", tt.baseURL, tt.key, err, tt.wantErr)
+		}
+	}
+
+	cfg := &Config{LLMBaseURL: llm.DefaultBaseURL, LLMCassette: "cassette.json", LLMCassetteMode: llm.CassetteStrict}
+	if err := cfg.CheckAPIKey(); err != nil {
+		t.Errorf("strict replay needs no key: %v", err)
+	}
+}
+
+func TestLoadTracker(t *testing.T) {
+	// Settings the tracker does not use are not read
+	t.Setenv("LLM_TIMEOUT", "soon")
+	t.Setenv("GMAIL_ACCOUNT_PROFILE", "work")
+	t.Setenv("TRACKER_STORE", "SQLite")
+
+	cfg, err := LoadTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AccountProfile != "work" || cfg.TrackerStore != "sqlite" {
+		t.Errorf("LoadTracker = profile %q, store %q", cfg.AccountProfile, cfg.TrackerStore)
+	}
+
+	t.Setenv("TRACKER_STORE", "csv")
+	if _, err := LoadTracker(); err == nil {
+		t.Errorf("LoadTracker accepted TRACKER_STORE=csv")
+	}
+}
@@ -0,0 +1,239 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ReportActionLabel   = "label"
+	ReportActionUnlabel = "unlabel"
+	ReportActionMove    = "move"
+	// ReportActionNone marks a message classified as not a newsletter.
+	ReportActionNone = "none"
+	// ReportActionSkip marks a message that could not be classified.
+	ReportActionSkip = "skip"
+)
+
+// ArchiveClient implements MailProvider over an exported mbox file or Maildir
+// directory. Nothing is ever changed on disk: label and move operations are
+// written to a DecisionReport instead.
+type ArchiveClient struct {
+	emails []*Email
+	byID   map[string]*Email
+	report *DecisionReport
+}
+
+func NewMboxClient(path string, report *DecisionReport) (*ArchiveClient, error) {
+	emails, err := ReadMbox(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveClient(emails, report), nil
+}
+
+// ReadMbox parses every message in an mbox file. IDs are the 1-based
+// position of the message in the file, counting messages that fail to parse,
+// so one bad message does not shift the IDs of those after it.
+func ReadMbox(path string) ([]*Email, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open mbox %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var emails []*Email
+	position := 0
+	err = splitMbox(f, func(raw []byte) {
+		position++
+		id := strconv.Itoa(position)
+		email, err := parseMessage(id, bytes.NewReader(raw))
+		if err != nil {
+			log.Printf("Skipping message in mbox %s: %v", path, err)
+			return
+		}
+		// Mail readers add R to the Status header once a message is read,
+		// and record flags, replies and deletions in X-Status
+		email.Unread = !strings.Contains(email.Header("Status"), "R")
+		xStatus := email.Header("X-Status")
+		email.Starred = strings.Contains(xStatus, "F")
+		email.Replied = strings.Contains(xStatus, "A")
+		email.Deleted = strings.Contains(xStatus, "D")
+		emails = append(emails, email)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mbox %s: %v", path, err)
+	}
+
+	return emails, nil
+}
+
+func NewMaildirClient(dir string, report *DecisionReport) (*ArchiveClient, error) {
+	var files []string
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to read maildir %s: %v", dir, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(sub, entry.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no messages found in maildir %s (expected cur/ or new/ subdirectories)", dir)
+	}
+	sort.Strings(files)
+
+	var emails []*Email
+	for _, name := range files {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("unable to open message %s: %v", name, err)
+		}
+		email, err := parseMessage(name, f)
+		f.Close()
+		if err != nil {
+			log.Printf("Skipping message in maildir %s: %v", dir, err)
+			continue
+		}
+		email.Unread = !maildirFlag(name, 'S')
+		email.Starred = maildirFlag(name, 'F')
+		email.Replied = maildirFlag(name, 'R')
+		email.Deleted = maildirFlag(name, 'T')
+		emails = append(emails, email)
+	}
+
+	return newArchiveClient(emails, report), nil
+}
+
+// maildirFlag reports whether a Maildir file name carries the flag, e.g. S
+// (seen) in "1700000000.M1.host:2,RS".
+func maildirFlag(name string, flag rune) bool {
+	i := strings.LastIndex(name, ":2,")
+	return i >= 0 && strings.ContainsRune(name[i+3:], flag)
+}
+
+func newArchiveClient(emails []*Email, report *DecisionReport) *ArchiveClient {
+	byID := make(map[string]*Email, len(emails))
+	for _, email := range emails {
+		byID[email.ID] = email
+	}
+
+	return &ArchiveClient{
+		emails: emails,
+		byID:   byID,
+		report: report,
+	}
+}
+
+func (a *ArchiveClient) Close() error {
+	return a.report.Close()
+}
+
+func (a *ArchiveClient) ListInboxEmails(ctx context.Context) ([]*Email, error) {
+	return a.emails, nil
+}
+
+func (a *ArchiveClient) GetEmail(ctx context.Context, messageID string) (*Email, error) {
+	email, exists := a.byID[messageID]
+	if !exists {
+		return nil, fmt.Errorf("message %s: %w", messageID, ErrNotFound)
+	}
+	return email, nil
+}
+
+func (a *ArchiveClient) CreateLabel(ctx context.Context, name string) error {
+	return nil
+}
+
+func (a *ArchiveClient) ApplyLabel(ctx context.Context, messageID, labelName string) error {
+	return a.record(messageID, labelName, ReportActionLabel)
+}
+
+func (a *ArchiveClient) RemoveLabel(ctx context.Context, messageID, labelName string) error {
+	return a.record(messageID, labelName, ReportActionUnlabel)
+}
+
+// ListLabelEmails is not supported because archives never carry the labels
+// written to the report.
+func (a *ArchiveClient) ListLabelEmails(ctx context.Context, labelName string) ([]*Email, error) {
+	return nil, fmt.Errorf("listing labeled messages is not supported for offline archives")
+}
+
+func (a *ArchiveClient) MoveEmail(ctx context.Context, messageID, labelName string) error {
+	return a.record(messageID, labelName, ReportActionMove)
+}
+
+// RecordDecision writes a report row for a message that gets no label.
+func (a *ArchiveClient) RecordDecision(ctx context.Context, messageID, labelName, action string) error {
+	return a.record(messageID, labelName, action)
+}
+
+func (a *ArchiveClient) record(messageID, labelName, action string) error {
+	email, err := a.GetEmail(context.Background(), messageID)
+	if err != nil {
+		return err
+	}
+
+	return a.report.Write(ReportRow{
+		MessageID: email.ID,
+		From:      email.From,
+		Subject:   email.Subject,
+		Label:     labelName,
+		Action:    action,
+	})
+}
+
+// splitMbox calls fn with each raw message in an mboxo/mboxrd stream,
+// removing the "From " separator line and one level of ">From " quoting.
+func splitMbox(r io.Reader, fn func(raw []byte)) error {
+	reader := bufio.NewReader(r)
+	var current bytes.Buffer
+	inMessage := false
+	prevBlank := true
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if prevBlank && bytes.HasPrefix(line, []byte("From ")) {
+				if inMessage {
+					fn(current.Bytes())
+				}
+				current = bytes.Buffer{}
+				inMessage = true
+			} else if inMessage {
+				if unquoted := strings.TrimLeft(string(line), ">"); len(unquoted) < len(line) && strings.HasPrefix(unquoted, "From ") {
+					line = line[1:]
+				}
+				current.Write(line)
+			}
+			prevBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if inMessage {
+		fn(current.Bytes())
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testMbox = "From news@example.com Mon Jan  1 00:00:00 2024\n" +
+	"From: news@example.com\n" +
+	"Subject: First\n" +
+	"\n" +
+	">From the editor\n" +
+	"\n" +
+	"From broken@example.com Mon Jan  1 00:00:00 2024\n" +
+	"not a header\n" +
+	"\n" +
+	"From news@example.com Mon Jan  1 00:00:00 2024\n" +
+	"From: news@example.com\n" +
+	"Subject: Third\n" +
+	"\n" +
+	"Body\n"
+
+func newTestMbox(t *testing.T, reportName string) (*ArchiveClient, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inbox.mbox")
+	if err := os.WriteFile(path, []byte(testMbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reportPath := filepath.Join(dir, reportName)
+	report, err := NewDecisionReport(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewMboxClient(path, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, reportPath
+}
+
+func TestMboxKeepsPositionsAfterBadMessage(t *testing.T) {
+	a, _ := newTestMbox(t, "decisions.csv")
+	defer a.Close()
+
+	emails, err := a.ListInboxEmails(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 2 {
+		t.Fatalf("read %d emails, want 2", len(emails))
+	}
+	if emails[0].ID != "1" || emails[0].Subject != "First" {
+		t.Errorf("first email = %+v", emails[0])
+	}
+	if emails[1].ID != "3" || emails[1].Subject != "Third" {
+		t.Errorf("third email = %+v", emails[1])
+	}
+}
+
+func TestArchiveReportsEveryDecision(t *testing.T) {
+	ctx := context.Background()
+	a, reportPath := newTestMbox(t, "decisions.jsonl")
+
+	if err := a.ApplyLabel(ctx, "1", "Newsletter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RecordDecision(ctx, "3", "", ReportActionNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var rows []ReportRow
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var row ReportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("report has %d rows, want 2", len(rows))
+	}
+	if rows[0].MessageID != "1" || rows[0].Label != "Newsletter" || rows[0].Action != ReportActionLabel {
+		t.Errorf("first row = %+v", rows[0])
+	}
+	if rows[1].MessageID != "3" || rows[1].Subject != "Third" || rows[1].Action != ReportActionNone {
+		t.Errorf("second row = %+v", rows[1])
+	}
+}
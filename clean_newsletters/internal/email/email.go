@@ -0,0 +1,111 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned by GetEmail for messages that no longer exist,
+// usually because the user deleted them for good.
+var ErrNotFound = errors.New("message not found")
+
+type Email struct {
+	ID   string
+	From string
+	// FromName and FromAddress are From split into display name and bare
+	// address, e.g. "Morning Brew" and crew@morningbrew.com.
+	FromName    string
+	FromAddress string
+	Subject     string
+	Body        string
+	Headers     map[string]string
+	// Unread is true until the user opens the message, as reported by the
+	// provider: Gmail's UNREAD label, the IMAP \Seen flag, Maildir's S flag
+	// or the mbox Status header.
+	Unread bool
+	// Starred, Replied and Deleted are the user's other actions on the
+	// message: Gmail's STARRED and TRASH labels and a sent message in the
+	// thread, the IMAP \Flagged, \Answered and \Deleted flags, Maildir's
+	// F, R and T flags, or the mbox X-Status header.
+	Starred bool
+	Replied bool
+	Deleted bool
+}
+
+// Header returns the first value of the named header. Names are matched
+// case-insensitively because providers differ in how they report them.
+func (e *Email) Header(name string) string {
+	if value, ok := e.Headers[name]; ok {
+		return value
+	}
+	for key, value := range e.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// ListID returns the list identifier from the List-Id header (RFC 2919),
+// e.g. morningbrew.substack.com, or "" when there is none.
+func (e *Email) ListID() string {
+	value := e.Header("List-Id")
+	if start := strings.LastIndex(value, "<"); start >= 0 {
+		if end := strings.Index(value[start:], ">"); end > 0 {
+			value = value[start+1 : start+end]
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// UnsubscribeURL returns the link from the List-Unsubscribe header (RFC
+// 2369), preferring an http(s) link over a mailto: one, or "" when there is
+// none.
+func (e *Email) UnsubscribeURL() string {
+	var mailto string
+	for _, part := range strings.Split(e.Header("List-Unsubscribe"), ",") {
+		link := strings.Trim(strings.TrimSpace(part), "<>")
+		switch lower := strings.ToLower(link); {
+		case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"):
+			return link
+		case strings.HasPrefix(lower, "mailto:") && mailto == "":
+			mailto = link
+		}
+	}
+	return mailto
+}
+
+// MailProvider is implemented by every mailbox backend the processor can
+// clean. Label names are provider-neutral: Gmail maps them to labels, IMAP
+// to folders or keywords.
+type MailProvider interface {
+	ListInboxEmails(ctx context.Context) ([]*Email, error)
+	GetEmail(ctx context.Context, messageID string) (*Email, error)
+	CreateLabel(ctx context.Context, name string) error
+	ApplyLabel(ctx context.Context, messageID, labelName string) error
+	RemoveLabel(ctx context.Context, messageID, labelName string) error
+	MoveEmail(ctx context.Context, messageID, labelName string) error
+	ListLabelEmails(ctx context.Context, labelName string) ([]*Email, error)
+}
+
+// DecisionRecorder is implemented by providers that report decisions
+// instead of applying them. The processor records the messages it leaves
+// unlabeled through it, so reports cover every processed message.
+type DecisionRecorder interface {
+	RecordDecision(ctx context.Context, messageID, labelName, action string) error
+}
+
+// HeaderReader is implemented by providers that can fetch a message's
+// headers and flags without downloading its body, which is all engagement
+// counting needs.
+type HeaderReader interface {
+	GetHeaders(ctx context.Context, messageID string) (*Email, error)
+}
+
+// Sender is implemented by providers that can send mail as the user.
+type Sender interface {
+	// SendEmail sends an HTML message. headers are added to the message,
+	// e.g. to mark it for later recognition.
+	SendEmail(ctx context.Context, to, subject, html string, headers map[string]string) error
+}
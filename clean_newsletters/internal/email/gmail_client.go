@@ -2,24 +2,24 @@ package email
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
 	"strings"
 
 	"clean_newsletters/internal/auth"
+	"clean_newsletters/internal/mailaddr"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 )
 
 type GmailClient struct {
 	auth *auth.GmailAuth
 }
 
-type Email struct {
-	ID      string
-	From    string
-	Subject string
-	Body    string
-}
-
 func NewGmailClient(auth *auth.GmailAuth) *GmailClient {
 	return &GmailClient{
 		auth: auth,
@@ -28,7 +28,7 @@ func NewGmailClient(auth *auth.GmailAuth) *GmailClient {
 
 func (g *GmailClient) ListInboxEmails(ctx context.Context) ([]*Email, error) {
 	user := "me"
-	
+
 	r, err := g.auth.Service.Users.Messages.List(user).Q("in:inbox").Do()
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve messages: %v", err)
@@ -41,29 +41,91 @@ func (g *GmailClient) ListInboxEmails(ctx context.Context) ([]*Email, error) {
 			continue
 		}
 
-		email := &Email{
-			ID: m.Id,
-		}
+		emails = append(emails, toEmail(msg))
+	}
+
+	return emails, nil
+}
+
+func (g *GmailClient) ListLabelEmails(ctx context.Context, labelName string) ([]*Email, error) {
+	labelID, err := g.labelID(labelName)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, header := range msg.Payload.Headers {
-			switch header.Name {
-			case "From":
-				email.From = header.Value
-			case "Subject":
-				email.Subject = header.Value
+	var emails []*Email
+	err = g.auth.Service.Users.Messages.List("me").LabelIds(labelID).Pages(ctx, func(r *gmail.ListMessagesResponse) error {
+		for _, m := range r.Messages {
+			msg, err := g.auth.Service.Users.Messages.Get("me", m.Id).Do()
+			if err != nil {
+				continue
 			}
-		}
 
-		email.Body = extractBody(msg.Payload)
-		emails = append(emails, email)
+			emails = append(emails, toEmail(msg))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve messages: %v", err)
 	}
 
 	return emails, nil
 }
 
+// GetEmail fetches one message. Unlike the list methods it also looks for
+// replies, which takes a second request to read the message's thread.
+func (g *GmailClient) GetEmail(ctx context.Context, messageID string) (*Email, error) {
+	return g.getEmail(messageID, "full")
+}
+
+// GetHeaders fetches the message in metadata format, without its body.
+func (g *GmailClient) GetHeaders(ctx context.Context, messageID string) (*Email, error) {
+	return g.getEmail(messageID, "metadata")
+}
+
+func (g *GmailClient) getEmail(messageID, format string) (*Email, error) {
+	msg, err := g.auth.Service.Users.Messages.Get("me", messageID).Format(format).Do()
+	if err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+			return nil, fmt.Errorf("unable to retrieve message %s: %w", messageID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("unable to retrieve message %s: %v", messageID, err)
+	}
+
+	email := toEmail(msg)
+	if msg.ThreadId != "" {
+		email.Replied = g.replied(msg)
+	}
+
+	return email, nil
+}
+
+// replied reports whether the user sent a message in msg's thread. A
+// thread that cannot be read counts as not replied to, so the message
+// itself is still returned.
+func (g *GmailClient) replied(msg *gmail.Message) bool {
+	thread, err := g.auth.Service.Users.Threads.Get("me", msg.ThreadId).Format("minimal").Do()
+	if err != nil {
+		log.Printf("Unable to retrieve thread %s of message %s: %v", msg.ThreadId, msg.Id, err)
+		return false
+	}
+
+	for _, m := range thread.Messages {
+		if m.Id == msg.Id {
+			continue
+		}
+		for _, label := range m.LabelIds {
+			if label == "SENT" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (g *GmailClient) CreateLabel(ctx context.Context, name string) error {
 	user := "me"
-	
+
 	labels, err := g.auth.Service.Users.Labels.List(user).Do()
 	if err != nil {
 		return fmt.Errorf("unable to list labels: %v", err)
@@ -90,41 +152,148 @@ func (g *GmailClient) CreateLabel(ctx context.Context, name string) error {
 }
 
 func (g *GmailClient) ApplyLabel(ctx context.Context, messageID, labelName string) error {
-	user := "me"
-	
-	labels, err := g.auth.Service.Users.Labels.List(user).Do()
+	labelID, err := g.labelID(labelName)
 	if err != nil {
-		return fmt.Errorf("unable to list labels: %v", err)
+		return err
 	}
 
-	var labelID string
-	for _, label := range labels.Labels {
-		if label.Name == labelName {
-			labelID = label.Id
-			break
-		}
+	modifyRequest := &gmail.ModifyMessageRequest{
+		AddLabelIds: []string{labelID},
+	}
+
+	_, err = g.auth.Service.Users.Messages.Modify("me", messageID, modifyRequest).Do()
+	if err != nil {
+		return fmt.Errorf("unable to apply label: %v", err)
+	}
+
+	return nil
+}
+
+func (g *GmailClient) RemoveLabel(ctx context.Context, messageID, labelName string) error {
+	labelID, err := g.labelID(labelName)
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := &gmail.ModifyMessageRequest{
+		RemoveLabelIds: []string{labelID},
 	}
 
-	if labelID == "" {
-		return fmt.Errorf("label %s not found", labelName)
+	_, err = g.auth.Service.Users.Messages.Modify("me", messageID, modifyRequest).Do()
+	if err != nil {
+		return fmt.Errorf("unable to remove label: %v", err)
+	}
+
+	return nil
+}
+
+// MoveEmail labels the message and takes it out of the inbox, which is how
+// Gmail represents moving a message into a folder.
+func (g *GmailClient) MoveEmail(ctx context.Context, messageID, labelName string) error {
+	labelID, err := g.labelID(labelName)
+	if err != nil {
+		return err
 	}
 
 	modifyRequest := &gmail.ModifyMessageRequest{
-		AddLabelIds: []string{labelID},
+		AddLabelIds:    []string{labelID},
+		RemoveLabelIds: []string{"INBOX"},
 	}
 
-	_, err = g.auth.Service.Users.Messages.Modify(user, messageID, modifyRequest).Do()
+	_, err = g.auth.Service.Users.Messages.Modify("me", messageID, modifyRequest).Do()
 	if err != nil {
-		return fmt.Errorf("unable to apply label: %v", err)
+		return fmt.Errorf("unable to move message: %v", err)
+	}
+
+	return nil
+}
+
+// SendEmail sends an HTML message from the authenticated account. Gmail
+// fills in the From header.
+func (g *GmailClient) SendEmail(ctx context.Context, to, subject, html string, headers map[string]string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, headers[name])
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	// Lines of encoded body are kept under the 78 character limit
+	body := base64.StdEncoding.EncodeToString([]byte(html))
+	for len(body) > 76 {
+		msg.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	msg.WriteString(body + "\r\n")
+
+	raw := base64.URLEncoding.EncodeToString([]byte(msg.String()))
+	_, err := g.auth.Service.Users.Messages.Send("me", &gmail.Message{Raw: raw}).Do()
+	if err != nil {
+		return fmt.Errorf("unable to send message: %v", err)
 	}
 
 	return nil
 }
 
+func (g *GmailClient) labelID(labelName string) (string, error) {
+	labels, err := g.auth.Service.Users.Labels.List("me").Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to list labels: %v", err)
+	}
+
+	for _, label := range labels.Labels {
+		if label.Name == labelName {
+			return label.Id, nil
+		}
+	}
+
+	return "", fmt.Errorf("label %s not found", labelName)
+}
+
+func toEmail(msg *gmail.Message) *Email {
+	email := &Email{
+		ID:      msg.Id,
+		Headers: make(map[string]string),
+	}
+
+	for _, header := range msg.Payload.Headers {
+		if _, exists := email.Headers[header.Name]; !exists {
+			email.Headers[header.Name] = header.Value
+		}
+		switch header.Name {
+		case "From":
+			email.From = header.Value
+		case "Subject":
+			email.Subject = header.Value
+		}
+	}
+
+	email.FromName, email.FromAddress = mailaddr.Parse(email.From)
+	email.Body = decodeBodyData(extractBody(msg.Payload))
+	for _, label := range msg.LabelIds {
+		switch label {
+		case "UNREAD":
+			email.Unread = true
+		case "STARRED":
+			email.Starred = true
+		case "TRASH":
+			email.Deleted = true
+		}
+	}
+	return email
+}
 
 func extractBody(payload *gmail.MessagePart) string {
 	var body string
-	
+
 	if payload.Body != nil && payload.Body.Data != "" {
 		body = payload.Body.Data
 	}
@@ -143,4 +312,16 @@ func extractBody(payload *gmail.MessagePart) string {
 	}
 
 	return body
-}
\ No newline at end of file
+}
+
+// decodeBodyData decodes the base64url body data returned by the Gmail API.
+func decodeBodyData(data string) string {
+	decoded, err := base64.URLEncoding.DecodeString(data)
+	if err != nil {
+		decoded, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+		if err != nil {
+			return data
+		}
+	}
+	return string(decoded)
+}
@@ -0,0 +1,140 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"clean_newsletters/internal/auth"
+	"clean_newsletters/internal/gmailfake"
+)
+
+func newTestGmail(t *testing.T) (*GmailClient, *gmailfake.Server) {
+	t.Helper()
+	srv := gmailfake.NewServer()
+	t.Cleanup(srv.Close)
+
+	gmailAuth, err := auth.NewGmailAuthWithOptions(context.Background(), srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGmailClient(gmailAuth), srv
+}
+
+func TestGmailListLabelEmailsPaginates(t *testing.T) {
+	ctx := context.Background()
+	g, srv := newTestGmail(t)
+
+	if err := g.CreateLabel(ctx, "Newsletter/Review"); err != nil {
+		t.Fatal(err)
+	}
+	// More than the fake server's page of 100
+	const count = 130
+	for i := 0; i < count; i++ {
+		id := srv.AddMessage(gmailfake.Message{From: "news@example.com", Subject: fmt.Sprintf("Issue %d", i)})
+		if err := g.ApplyLabel(ctx, id, "Newsletter/Review"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.AddMessage(gmailfake.Message{From: "friend@example.com", Subject: "Unlabeled"})
+
+	emails, err := g.ListLabelEmails(ctx, "Newsletter/Review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != count {
+		t.Errorf("ListLabelEmails returned %d emails, want %d", len(emails), count)
+	}
+}
+
+func TestGmailGetHeaders(t *testing.T) {
+	ctx := context.Background()
+	g, srv := newTestGmail(t)
+
+	id := srv.AddMessage(gmailfake.Message{
+		From:     "news@example.com",
+		Subject:  "Issue 1",
+		Body:     "A long newsletter body",
+		ThreadID: "thread1",
+		Headers:  map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+		Labels:   []string{"INBOX", "STARRED"},
+	})
+	srv.AddMessage(gmailfake.Message{From: "me", Subject: "Re: Issue 1", ThreadID: "thread1", Labels: []string{"SENT"}})
+
+	e, err := g.GetHeaders(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Body != "" {
+		t.Errorf("GetHeaders fetched the body %q", e.Body)
+	}
+	if e.Header("List-Unsubscribe") != "<https://example.com/unsubscribe>" || e.Unread || !e.Starred || !e.Replied {
+		t.Errorf("GetHeaders = %+v", e)
+	}
+
+	full, err := g.GetEmail(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Body != "A long newsletter body" {
+		t.Errorf("GetEmail body = %q", full.Body)
+	}
+}
+
+func TestGmailSendEmail(t *testing.T) {
+	ctx := context.Background()
+	g, srv := newTestGmail(t)
+
+	html := "<p>" + strings.Repeat("Newsletter digest body. ", 20) + "</p>"
+	headers := map[string]string{"X-B": "2", "X-A": "1"}
+	if err := g.SendEmail(ctx, "me@example.com", "Digest für heute", html, headers); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := srv.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	if sent[0].Subject != "Digest für heute" || sent[0].Body != html || sent[0].Headers["X-A"] != "1" {
+		t.Errorf("sent message = %+v", sent[0])
+	}
+
+	msg, err := g.auth.Service.Users.Messages.Get("me", sent[0].ID).Format("raw").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.URLEncoding.DecodeString(msg.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, body, ok := strings.Cut(string(raw), "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no header/body separator in %q", raw)
+	}
+	wantHead := "To: me@example.com\r\n" +
+		"Subject: =?utf-8?q?Digest_f=C3=BCr_heute?=\r\n" +
+		"X-A: 1\r\nX-B: 2\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: base64"
+	if head != wantHead {
+		t.Errorf("headers:\n%s\nwant:\n%s", head, wantHead)
+	}
+
+	// The body is base64 in lines of 76 characters
+	lines := strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n")
+	if len(lines) < 2 {
+		t.Fatalf("body is %d lines, want it wrapped", len(lines))
+	}
+	for i, line := range lines {
+		if len(line) > 76 || (i < len(lines)-1 && len(line) != 76) {
+			t.Errorf("line %d is %d characters", i, len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil || string(decoded) != html {
+		t.Errorf("decoded body = %q, %v", decoded, err)
+	}
+}
@@ -0,0 +1,414 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"clean_newsletters/internal/config"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+const (
+	IMAPLabelFolder  = "folder"
+	IMAPLabelKeyword = "keyword"
+)
+
+// IMAPClient implements MailProvider for any IMAP server. Message IDs are
+// UIDs in the configured mailbox. Labels become folders (the message is
+// copied there) or IMAP keywords set on the message, depending on the
+// configured label mode.
+type IMAPClient struct {
+	mu        sync.Mutex
+	client    *client.Client
+	mailbox   string
+	labelMode string
+}
+
+func NewIMAPClient(cfg *config.Config) (*IMAPClient, error) {
+	var c *client.Client
+	var err error
+	if cfg.IMAPTLS {
+		c, err = client.DialTLS(cfg.IMAPAddress, nil)
+	} else {
+		c, err = client.Dial(cfg.IMAPAddress)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to IMAP server %s: %v", cfg.IMAPAddress, err)
+	}
+
+	if err := c.Login(cfg.IMAPUsername, cfg.IMAPPassword); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("unable to log in to IMAP server as %s: %v\n\nFor Fastmail and most hosted providers use an app-specific password", cfg.IMAPUsername, err)
+	}
+
+	return &IMAPClient{
+		client:    c,
+		mailbox:   cfg.IMAPMailbox,
+		labelMode: cfg.IMAPLabelMode,
+	}, nil
+}
+
+func (i *IMAPClient) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.client.Logout()
+}
+
+func (i *IMAPClient) ListInboxEmails(ctx context.Context) ([]*Email, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	status, err := i.client.Select(i.mailbox, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to select mailbox %s: %v", i.mailbox, err)
+	}
+	if status.Messages == 0 {
+		return nil, nil
+	}
+
+	uids, err := i.client.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve messages: %v", err)
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	return i.fetch(seqset)
+}
+
+func (i *IMAPClient) GetEmail(ctx context.Context, messageID string) (*Email, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	seqset, err := i.selectMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, err := i.fetch(seqset)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, fmt.Errorf("message %s: %w", messageID, ErrNotFound)
+	}
+
+	return emails[0], nil
+}
+
+func (i *IMAPClient) CreateLabel(ctx context.Context, name string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.labelMode == IMAPLabelKeyword {
+		status, err := i.client.Select(i.mailbox, false)
+		if err != nil {
+			return fmt.Errorf("unable to select mailbox %s: %v", i.mailbox, err)
+		}
+		for _, flag := range status.PermanentFlags {
+			if flag == imap.TryCreateFlag || flag == labelKeyword(name) {
+				return nil
+			}
+		}
+		return fmt.Errorf("mailbox %s does not allow custom keywords, use IMAP_LABEL_MODE=%s", i.mailbox, IMAPLabelFolder)
+	}
+
+	return i.createFolder(name)
+}
+
+func (i *IMAPClient) ApplyLabel(ctx context.Context, messageID, labelName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	seqset, err := i.selectMessage(messageID)
+	if err != nil {
+		return err
+	}
+
+	if i.labelMode == IMAPLabelKeyword {
+		item := imap.FormatFlagsOp(imap.AddFlags, true)
+		if err := i.client.UidStore(seqset, item, []interface{}{labelKeyword(labelName)}, nil); err != nil {
+			return fmt.Errorf("unable to apply label: %v", err)
+		}
+		return nil
+	}
+
+	if err := i.client.UidCopy(seqset, labelName); err != nil {
+		return fmt.Errorf("unable to apply label: %v", err)
+	}
+
+	return nil
+}
+
+func (i *IMAPClient) RemoveLabel(ctx context.Context, messageID, labelName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	seqset, err := i.selectMessage(messageID)
+	if err != nil {
+		return err
+	}
+
+	if i.labelMode == IMAPLabelKeyword {
+		item := imap.FormatFlagsOp(imap.RemoveFlags, true)
+		if err := i.client.UidStore(seqset, item, []interface{}{labelKeyword(labelName)}, nil); err != nil {
+			return fmt.Errorf("unable to remove label: %v", err)
+		}
+		return nil
+	}
+
+	// In folder mode the label is a copy of the message in the label folder,
+	// found again by its Message-Id.
+	emails, err := i.fetch(seqset)
+	if err != nil {
+		return err
+	}
+	if len(emails) == 0 {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+	messageIDHeader := emails[0].Headers["Message-Id"]
+	if messageIDHeader == "" {
+		return fmt.Errorf("message %s has no Message-Id, cannot find its copy in %s", messageID, labelName)
+	}
+
+	if _, err := i.client.Select(labelName, false); err != nil {
+		return fmt.Errorf("unable to select folder %s: %v", labelName, err)
+	}
+	uids, err := i.searchMessageID(messageIDHeader)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	copies := new(imap.SeqSet)
+	copies.AddNum(uids...)
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := i.client.UidStore(copies, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return fmt.Errorf("unable to remove label: %v", err)
+	}
+	if err := i.client.Expunge(nil); err != nil {
+		return fmt.Errorf("unable to remove label: %v", err)
+	}
+
+	return nil
+}
+
+// ListLabelEmails returns the mailbox messages carrying the label. IDs are
+// always UIDs in the configured mailbox, so in folder mode the copies are
+// matched back to the mailbox by Message-Id and fetched from there, where
+// their flags reflect what the user has done with them.
+func (i *IMAPClient) ListLabelEmails(ctx context.Context, labelName string) ([]*Email, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.labelMode == IMAPLabelKeyword {
+		if _, err := i.client.Select(i.mailbox, false); err != nil {
+			return nil, fmt.Errorf("unable to select mailbox %s: %v", i.mailbox, err)
+		}
+		criteria := imap.NewSearchCriteria()
+		criteria.WithFlags = []string{labelKeyword(labelName)}
+		uids, err := i.client.UidSearch(criteria)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve messages: %v", err)
+		}
+		if len(uids) == 0 {
+			return nil, nil
+		}
+		seqset := new(imap.SeqSet)
+		seqset.AddNum(uids...)
+		return i.fetch(seqset)
+	}
+
+	status, err := i.client.Select(labelName, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to select folder %s: %v", labelName, err)
+	}
+	if status.Messages == 0 {
+		return nil, nil
+	}
+	all := new(imap.SeqSet)
+	all.AddRange(1, status.Messages)
+	copies, err := i.fetchSeq(all)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := i.client.Select(i.mailbox, false); err != nil {
+		return nil, fmt.Errorf("unable to select mailbox %s: %v", i.mailbox, err)
+	}
+	seqset := new(imap.SeqSet)
+	for _, c := range copies {
+		messageIDHeader := c.Headers["Message-Id"]
+		if messageIDHeader == "" {
+			continue
+		}
+		uids, err := i.searchMessageID(messageIDHeader)
+		if err != nil {
+			return nil, err
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		seqset.AddNum(uids[0])
+	}
+	if seqset.Empty() {
+		return nil, nil
+	}
+
+	return i.fetch(seqset)
+}
+
+func (i *IMAPClient) searchMessageID(messageID string) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-Id", messageID)
+	uids, err := i.client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search for message %s: %v", messageID, err)
+	}
+	return uids, nil
+}
+
+// MoveEmail moves the message out of the mailbox into the folder named after
+// the label. The folder is created on demand so this also works in keyword
+// mode, where CreateLabel does not create folders.
+func (i *IMAPClient) MoveEmail(ctx context.Context, messageID, labelName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.createFolder(labelName); err != nil {
+		return err
+	}
+
+	seqset, err := i.selectMessage(messageID)
+	if err != nil {
+		return err
+	}
+
+	if err := i.client.UidMove(seqset, labelName); err != nil {
+		return fmt.Errorf("unable to move message: %v", err)
+	}
+
+	return nil
+}
+
+func (i *IMAPClient) selectMessage(messageID string) (*imap.SeqSet, error) {
+	uid, err := strconv.ParseUint(messageID, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAP message id %q: %v", messageID, err)
+	}
+
+	if _, err := i.client.Select(i.mailbox, false); err != nil {
+		return nil, fmt.Errorf("unable to select mailbox %s: %v", i.mailbox, err)
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uint32(uid))
+	return seqset, nil
+}
+
+func (i *IMAPClient) createFolder(name string) error {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- i.client.List("", name, mailboxes)
+	}()
+
+	exists := false
+	for m := range mailboxes {
+		if m.Name == name {
+			exists = true
+		}
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("unable to list folders: %v", err)
+	}
+	if exists {
+		return nil
+	}
+
+	if err := i.client.Create(name); err != nil {
+		return fmt.Errorf("unable to create folder: %v", err)
+	}
+
+	return nil
+}
+
+func (i *IMAPClient) fetch(seqset *imap.SeqSet) ([]*Email, error) {
+	return i.fetchMessages(seqset, true)
+}
+
+func (i *IMAPClient) fetchSeq(seqset *imap.SeqSet) ([]*Email, error) {
+	return i.fetchMessages(seqset, false)
+}
+
+func (i *IMAPClient) fetchMessages(seqset *imap.SeqSet, byUID bool) ([]*Email, error) {
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags, section.FetchItem()}
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		if byUID {
+			done <- i.client.UidFetch(seqset, items, messages)
+		} else {
+			done <- i.client.Fetch(seqset, items, messages)
+		}
+	}()
+
+	var emails []*Email
+	for msg := range messages {
+		id := strconv.FormatUint(uint64(msg.Uid), 10)
+		body := msg.GetBody(section)
+		if body == nil {
+			log.Printf("Skipping IMAP message %s: server returned no body", id)
+			continue
+		}
+
+		email, err := parseMessage(id, body)
+		if err != nil {
+			log.Printf("Skipping IMAP message: %v", err)
+			continue
+		}
+		email.Unread = !hasFlag(msg.Flags, imap.SeenFlag)
+		email.Starred = hasFlag(msg.Flags, imap.FlaggedFlag)
+		email.Replied = hasFlag(msg.Flags, imap.AnsweredFlag)
+		email.Deleted = hasFlag(msg.Flags, imap.DeletedFlag)
+		emails = append(emails, email)
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("unable to fetch messages: %v", err)
+	}
+
+	return emails, nil
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelKeyword converts a label name into a valid IMAP keyword atom.
+func labelKeyword(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune(`(){%*"\]`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,252 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+// moveBackend adds MOVE to the memory backend, whose server advertises the
+// extension without the backend implementing it.
+type moveBackend struct{ backend.Backend }
+
+type moveUser struct{ backend.User }
+
+type moveMailbox struct{ backend.Mailbox }
+
+func (b moveBackend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return moveUser{user}, nil
+}
+
+func (u moveUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mailbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return moveMailbox{mailbox}, nil
+}
+
+func (m moveMailbox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	if err := m.CopyMessages(uid, seqset, dest); err != nil {
+		return err
+	}
+	if err := m.UpdateMessagesFlags(uid, seqset, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+	return m.Expunge()
+}
+
+// newTestIMAP starts go-imap's in-memory server with the given messages
+// appended to INBOX and returns a client for it in the given label mode.
+// The memory backend starts with one message of its own, which is
+// expunged first.
+func newTestIMAP(t *testing.T, labelMode string, messages ...string) *IMAPClient {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(moveBackend{memory.New()})
+	srv.AllowInsecureAuth = true
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := client.Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatal(err)
+	}
+	all := new(imap.SeqSet)
+	all.AddRange(1, 0)
+	if err := c.Store(all, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Expunge(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		if err := c.Append("INBOX", nil, time.Now(), bytes.NewBufferString(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i := &IMAPClient{client: c, mailbox: "INBOX", labelMode: labelMode}
+	t.Cleanup(func() { i.Close() })
+	return i
+}
+
+func testMessage(n int, from string) string {
+	return fmt.Sprintf("From: %s\r\n"+
+		"Subject: Issue %d\r\n"+
+		"Message-Id: <issue%d@example.com>\r\n"+
+		"List-Id: Weekly <weekly.example.com>\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"Body of issue %d\r\n", from, n, n, n)
+}
+
+func subjects(emails []*Email) []string {
+	var subjects []string
+	for _, e := range emails {
+		subjects = append(subjects, e.Subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+func TestIMAPListInboxEmails(t *testing.T) {
+	ctx := context.Background()
+	i := newTestIMAP(t, IMAPLabelKeyword, testMessage(1, `"Weekly" <news@example.com>`), testMessage(2, "other@example.org"))
+
+	emails, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(emails); fmt.Sprint(got) != "[Issue 1 Issue 2]" {
+		t.Fatalf("subjects = %v", got)
+	}
+
+	e, err := i.GetEmail(ctx, emails[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.From == "" || e.Headers["List-Id"] != "Weekly <weekly.example.com>" {
+		t.Errorf("GetEmail = %+v", e)
+	}
+
+	if _, err := i.GetEmail(ctx, "999"); err == nil {
+		t.Errorf("GetEmail of a missing UID succeeded")
+	}
+}
+
+// keywordUIDs returns the UIDs in INBOX carrying the label's keyword.
+func keywordUIDs(t *testing.T, i *IMAPClient, label string) []uint32 {
+	t.Helper()
+	if _, err := i.client.Select("INBOX", true); err != nil {
+		t.Fatal(err)
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{labelKeyword(label)}
+	uids, err := i.client.UidSearch(criteria)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uids
+}
+
+func TestIMAPKeywordLabels(t *testing.T) {
+	ctx := context.Background()
+	i := newTestIMAP(t, IMAPLabelKeyword, testMessage(1, "news@example.com"), testMessage(2, "news@example.com"))
+
+	if err := i.CreateLabel(ctx, "Newsletter/Review"); err != nil {
+		t.Fatal(err)
+	}
+	emails, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.ApplyLabel(ctx, emails[0].ID, "Newsletter/Review"); err != nil {
+		t.Fatal(err)
+	}
+
+	uids := keywordUIDs(t, i, "Newsletter/Review")
+	if len(uids) != 1 || fmt.Sprint(uids[0]) != emails[0].ID {
+		t.Fatalf("UIDs with the keyword = %v, want %s", uids, emails[0].ID)
+	}
+
+	// Keyword labels leave the message in the mailbox
+	inbox, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 2 {
+		t.Errorf("inbox has %d messages, want 2", len(inbox))
+	}
+}
+
+func TestIMAPFolderLabels(t *testing.T) {
+	ctx := context.Background()
+	i := newTestIMAP(t, IMAPLabelFolder, testMessage(1, "news@example.com"), testMessage(2, "news@example.com"))
+
+	if err := i.CreateLabel(ctx, "Newsletter"); err != nil {
+		t.Fatal(err)
+	}
+	// Creating an existing folder is not an error
+	if err := i.CreateLabel(ctx, "Newsletter"); err != nil {
+		t.Fatal(err)
+	}
+
+	emails, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.ApplyLabel(ctx, emails[1].ID, "Newsletter"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Folder labels copy the message and leave the original in place
+	status, err := i.client.Select("Newsletter", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Messages != 1 {
+		t.Errorf("Newsletter has %d messages, want 1", status.Messages)
+	}
+	inbox, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 2 {
+		t.Errorf("inbox has %d messages, want 2", len(inbox))
+	}
+}
+
+func TestIMAPMoveEmail(t *testing.T) {
+	ctx := context.Background()
+	i := newTestIMAP(t, IMAPLabelKeyword, testMessage(1, "news@example.com"), testMessage(2, "news@example.com"))
+
+	emails, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.MoveEmail(ctx, emails[0].ID, "Unsubscribe"); err != nil {
+		t.Fatal(err)
+	}
+
+	inbox, err := i.ListInboxEmails(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 || inbox[0].ID != emails[1].ID {
+		t.Fatalf("inbox after move = %v", subjects(inbox))
+	}
+
+	status, err := i.client.Select("Unsubscribe", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Messages != 1 {
+		t.Errorf("Unsubscribe has %d messages, want 1", status.Messages)
+	}
+}
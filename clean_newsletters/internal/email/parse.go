@@ -0,0 +1,120 @@
+package email
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"clean_newsletters/internal/mailaddr"
+)
+
+var headerDecoder = &mime.WordDecoder{}
+
+// parseMessage turns a raw RFC 5322 message into an Email. It is shared by
+// every provider that works with raw messages so they all see the same
+// headers and body text.
+func parseMessage(id string, r io.Reader) (*Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse message %s: %v", id, err)
+	}
+
+	email := &Email{
+		ID:      id,
+		Headers: make(map[string]string),
+	}
+
+	for name, values := range msg.Header {
+		if len(values) == 0 {
+			continue
+		}
+		email.Headers[name] = decodeHeader(values[0])
+	}
+	email.From = email.Headers["From"]
+	email.FromName, email.FromAddress = mailaddr.Parse(email.From)
+	email.Subject = email.Headers["Subject"]
+
+	body, err := extractMIMEBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read body of message %s: %v", id, err)
+	}
+	email.Body = body
+
+	return email, nil
+}
+
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// extractMIMEBody returns the text/plain part of a message, falling back to
+// the first text/html part when there is no plain text alternative.
+func extractMIMEBody(contentType, transferEncoding string, r io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		data, err := io.ReadAll(decodeTransfer(transferEncoding, r))
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(mediaType, "text/") {
+			return "", nil
+		}
+		return string(data), nil
+	}
+
+	var htmlBody string
+	reader := multipart.NewReader(r, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		partType := part.Header.Get("Content-Type")
+		body, err := extractMIMEBody(partType, part.Header.Get("Content-Transfer-Encoding"), part)
+		if err != nil {
+			return "", err
+		}
+		if body == "" {
+			continue
+		}
+
+		partMediaType, _, _ := mime.ParseMediaType(partType)
+		if partMediaType == "text/html" {
+			if htmlBody == "" {
+				htmlBody = body
+			}
+			continue
+		}
+		return body, nil
+	}
+
+	return htmlBody, nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
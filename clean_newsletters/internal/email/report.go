@@ -0,0 +1,94 @@
+package email
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ReportRow struct {
+	Timestamp time.Time `json:"timestamp"`
+	MessageID string    `json:"message_id"`
+	From      string    `json:"from"`
+	Subject   string    `json:"subject"`
+	Label     string    `json:"label"`
+	Action    string    `json:"action"`
+}
+
+// DecisionReport records the labels the processor would have applied. The
+// format is chosen from the file extension: .jsonl writes one JSON object per
+// line, anything else writes CSV.
+type DecisionReport struct {
+	mu   sync.Mutex
+	file *os.File
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func NewDecisionReport(path string) (*DecisionReport, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create report %s: %v", path, err)
+	}
+
+	report := &DecisionReport{file: f}
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		report.json = json.NewEncoder(f)
+		return report, nil
+	}
+
+	report.csv = csv.NewWriter(f)
+	if err := report.csv.Write([]string{"timestamp", "message_id", "from", "subject", "label", "action"}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to write report header: %v", err)
+	}
+	return report, nil
+}
+
+func (r *DecisionReport) Write(row ReportRow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if row.Timestamp.IsZero() {
+		row.Timestamp = time.Now()
+	}
+
+	if r.json != nil {
+		if err := r.json.Encode(row); err != nil {
+			return fmt.Errorf("unable to write report row: %v", err)
+		}
+		return nil
+	}
+
+	err := r.csv.Write([]string{
+		row.Timestamp.Format(time.RFC3339),
+		row.MessageID,
+		row.From,
+		row.Subject,
+		row.Label,
+		row.Action,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write report row: %v", err)
+	}
+	return nil
+}
+
+func (r *DecisionReport) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.csv != nil {
+		r.csv.Flush()
+		if err := r.csv.Error(); err != nil {
+			r.file.Close()
+			return err
+		}
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,75 @@
+package embedding
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// Document is the text embedded for one sender.
+type Document struct {
+	Sender  string
+	Subject string
+}
+
+// Embedder turns documents into vectors. Name identifies the model so stored
+// vectors from a different model are never compared.
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, docs []Document) ([][]float32, error)
+}
+
+// Match is one search result.
+type Match struct {
+	Key   string
+	Score float64
+}
+
+// Index is a brute-force nearest-neighbour index using cosine similarity,
+// which is plenty for the few thousand senders a mailbox accumulates.
+type Index struct {
+	keys    []string
+	vectors [][]float32
+}
+
+func (i *Index) Add(key string, vector []float32) {
+	i.keys = append(i.keys, key)
+	i.vectors = append(i.vectors, vector)
+}
+
+func (i *Index) Len() int {
+	return len(i.keys)
+}
+
+// Search returns the k entries most similar to vector, best first.
+func (i *Index) Search(vector []float32, k int) []Match {
+	matches := make([]Match, 0, len(i.keys))
+	for n, v := range i.vectors {
+		matches = append(matches, Match{Key: i.keys[n], Score: Cosine(vector, v)})
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].Score > matches[b].Score
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
+
+// Cosine returns the cosine similarity of two vectors, or 0 when their
+// lengths differ or either is all zeros.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
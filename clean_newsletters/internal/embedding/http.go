@@ -0,0 +1,112 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPEmbedder calls an OpenAI-compatible /embeddings endpoint.
+type HTTPEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func NewHTTPEmbedder(baseURL, apiKey, model string, timeout time.Duration) *HTTPEmbedder {
+	return &HTTPEmbedder{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *HTTPEmbedder) Name() string {
+	return h.model
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (h *HTTPEmbedder) Embed(ctx context.Context, docs []Document) ([][]float32, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	input := make([]string, len(docs))
+	for i, doc := range docs {
+		input[i] = fmt.Sprintf("From: %s\nSubject: %s", doc.Sender, doc.Subject)
+	}
+
+	jsonData, err := json.Marshal(embeddingRequest{Model: h.model, Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.baseURL+"/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var embResp embeddingResponse
+	unmarshalErr := json.Unmarshal(body, &embResp)
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(body))
+		if unmarshalErr == nil && embResp.Error != nil {
+			message = embResp.Error.Message
+		}
+		return nil, fmt.Errorf("embeddings request failed with status %d: %s", resp.StatusCode, message)
+	}
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", unmarshalErr)
+	}
+
+	vectors := make([][]float32, len(docs))
+	for _, item := range embResp.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+
+	return vectors, nil
+}
@@ -0,0 +1,124 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// lexicalDimensions is the size of the hashed feature space.
+const lexicalDimensions = 1024
+
+// Tokens that say nothing about who the sender is.
+var lexicalStopwords = map[string]bool{
+	"com": true, "net": true, "org": true, "io": true, "co": true, "uk": true,
+	"www": true, "mail": true, "email": true, "info": true, "hello": true,
+	"noreply": true, "no": true, "reply": true, "team": true, "the": true,
+}
+
+// LexicalEmbedder is the built-in fallback used when no embeddings endpoint
+// is configured: TF-IDF over the tokens of the sender's display name,
+// address and domain, hashed into a fixed number of dimensions. The IDF
+// weights come from the corpus it was created with, normally the subscribed
+// senders, so its vectors are only comparable with others from the same
+// embedder and are cheap enough to recompute rather than store.
+type LexicalEmbedder struct {
+	idf map[string]float64
+	// defaultIDF weights tokens that do not appear in the corpus.
+	defaultIDF float64
+}
+
+func NewLexicalEmbedder(corpus []string) *LexicalEmbedder {
+	df := make(map[string]int)
+	for _, sender := range corpus {
+		seen := make(map[string]bool)
+		for _, token := range senderTokens(sender) {
+			if !seen[token] {
+				seen[token] = true
+				df[token]++
+			}
+		}
+	}
+
+	n := float64(len(corpus))
+	idf := make(map[string]float64, len(df))
+	for token, count := range df {
+		idf[token] = math.Log((1+n)/(1+float64(count))) + 1
+	}
+
+	return &LexicalEmbedder{
+		idf:        idf,
+		defaultIDF: math.Log(1+n) + 1,
+	}
+}
+
+func (l *LexicalEmbedder) Name() string {
+	return "lexical-tfidf"
+}
+
+// Embed only looks at the sender; subjects vary too much between issues of
+// the same newsletter to help a lexical match.
+func (l *LexicalEmbedder) Embed(ctx context.Context, docs []Document) ([][]float32, error) {
+	vectors := make([][]float32, len(docs))
+	for i, doc := range docs {
+		vectors[i] = l.embed(doc.Sender)
+	}
+	return vectors, nil
+}
+
+func (l *LexicalEmbedder) embed(sender string) []float32 {
+	counts := make(map[string]int)
+	for _, token := range senderTokens(sender) {
+		counts[token]++
+	}
+
+	vector := make([]float32, lexicalDimensions)
+	var norm float64
+	for token, count := range counts {
+		idf, ok := l.idf[token]
+		if !ok {
+			idf = l.defaultIDF
+		}
+		weight := (1 + math.Log(float64(count))) * idf
+
+		h := fnv.New32a()
+		h.Write([]byte(token))
+		vector[h.Sum32()%lexicalDimensions] += float32(weight)
+		norm += weight * weight
+	}
+
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] = float32(float64(vector[i]) / norm)
+		}
+	}
+	return vector
+}
+
+// senderTokens splits a From value into lowercase words from the display
+// name, the local part and the domain labels, plus the full domain so an
+// exact domain match counts for more than shared words.
+func senderTokens(sender string) []string {
+	sender = strings.ToLower(sender)
+
+	var tokens []string
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		domain := strings.TrimRight(sender[at+1:], "> \t\"")
+		if domain != "" {
+			tokens = append(tokens, "@"+domain)
+		}
+	}
+
+	words := strings.FieldsFunc(sender, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if len(word) > 1 && !lexicalStopwords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,579 @@
+// Package gmailfake is an in-memory stand-in for the parts of the Gmail REST
+// API that clean_newsletters uses: messages list/get/modify/send, threads
+// get, labels list/create and history. It lets the whole ProcessInbox flow run offline:
+//
+//	srv := gmailfake.NewServer()
+//	defer srv.Close()
+//	srv.AddMessage(gmailfake.Message{From: "news@example.com", Subject: "Weekly"})
+//	gmailAuth, _ := auth.NewGmailAuthWithOptions(ctx, srv.ClientOptions()...)
+//	client := email.NewGmailClient(gmailAuth)
+package gmailfake
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+const pageSize = 100
+
+var systemLabels = []string{"INBOX", "UNREAD", "STARRED", "IMPORTANT", "SENT", "TRASH", "SPAM"}
+
+// Message is a fixture message. Labels are label IDs; when empty the message
+// is delivered to the inbox as unread.
+type Message struct {
+	ID       string            `json:"id"`
+	ThreadID string            `json:"thread_id"`
+	From     string            `json:"from"`
+	Subject  string            `json:"subject"`
+	Body     string            `json:"body"`
+	Headers  map[string]string `json:"headers"`
+	Labels   []string          `json:"labels"`
+}
+
+type Server struct {
+	mu        sync.Mutex
+	messages  map[string]*gmail.Message
+	raw       map[string][]byte
+	order     []string
+	labels    map[string]*gmail.Label
+	history   []*gmail.History
+	historyID uint64
+	nextID    int
+	address   string
+	http      *httptest.Server
+}
+
+func NewServer() *Server {
+	s := &Server{
+		messages: make(map[string]*gmail.Message),
+		raw:      make(map[string][]byte),
+		labels:   make(map[string]*gmail.Label),
+	}
+	for _, id := range systemLabels {
+		s.labels[id] = &gmail.Label{Id: id, Name: id, Type: "system"}
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.http.URL
+}
+
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// ClientOptions points a gmail.Service at the fake server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.http.URL + "/"),
+		option.WithHTTPClient(s.http.Client()),
+	}
+}
+
+// AddMessage stores a fixture message and returns its ID.
+func (s *Server) AddMessage(m Message) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addMessage(m)
+}
+
+func (s *Server) addMessage(m Message) string {
+	s.nextID++
+	if m.ID == "" {
+		m.ID = fmt.Sprintf("msg%04d", s.nextID)
+	}
+	if m.ThreadID == "" {
+		m.ThreadID = m.ID
+	}
+	labels := m.Labels
+	if len(labels) == 0 {
+		labels = []string{"INBOX", "UNREAD"}
+	}
+
+	headers := []*gmail.MessagePartHeader{
+		{Name: "From", Value: m.From},
+		{Name: "Subject", Value: m.Subject},
+	}
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		headers = append(headers, &gmail.MessagePartHeader{Name: name, Value: m.Headers[name]})
+	}
+
+	msg := &gmail.Message{
+		Id:       m.ID,
+		ThreadId: m.ThreadID,
+		LabelIds: append([]string(nil), labels...),
+		Snippet:  snippet(m.Body),
+		Payload: &gmail.MessagePart{
+			MimeType: "text/plain",
+			Headers:  headers,
+			Body: &gmail.MessagePartBody{
+				Data: base64.URLEncoding.EncodeToString([]byte(m.Body)),
+				Size: int64(len(m.Body)),
+			},
+		},
+	}
+
+	if _, exists := s.messages[m.ID]; !exists {
+		s.order = append(s.order, m.ID)
+	}
+	s.messages[m.ID] = msg
+	s.recordHistory(&gmail.History{MessagesAdded: []*gmail.HistoryMessageAdded{{Message: stub(msg)}}})
+	return m.ID
+}
+
+// LoadFixtures adds every message from a JSON array of Message fixtures.
+func (s *Server) LoadFixtures(data []byte) ([]string, error) {
+	var fixtures []Message
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("unable to parse fixtures: %v", err)
+	}
+
+	ids := make([]string, 0, len(fixtures))
+	for _, m := range fixtures {
+		ids = append(ids, s.AddMessage(m))
+	}
+	return ids, nil
+}
+
+// LabelNames returns the names of the labels currently on a message.
+func (s *Server) LabelNames(messageID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, exists := s.messages[messageID]
+	if !exists {
+		return nil
+	}
+
+	var names []string
+	for _, id := range msg.LabelIds {
+		if label, ok := s.labels[id]; ok {
+			names = append(names, label.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetAddress sets the account's own address. Messages sent to it are also
+// delivered to the inbox, as Gmail does.
+func (s *Server) SetAddress(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.address = address
+}
+
+// Sent returns the messages sent through the API, oldest first.
+func (s *Server) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sent []Message
+	for _, id := range s.order {
+		if _, exists := s.raw[id]; !exists {
+			continue
+		}
+		msg := s.messages[id]
+		m := Message{ID: id, ThreadID: msg.ThreadId, Headers: make(map[string]string), Labels: msg.LabelIds}
+		for _, h := range msg.Payload.Headers {
+			switch h.Name {
+			case "From":
+				m.From = h.Value
+			case "Subject":
+				m.Subject = h.Value
+			default:
+				m.Headers[h.Name] = h.Value
+			}
+		}
+		body, _ := base64.URLEncoding.DecodeString(msg.Payload.Body.Data)
+		m.Body = string(body)
+		sent = append(sent, m)
+	}
+	return sent
+}
+
+// HistoryID returns the current mailbox history ID.
+func (s *Server) HistoryID() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.historyID
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/")
+	if path == r.URL.Path {
+		writeError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodGet:
+		s.listMessages(w, r)
+	case len(parts) == 3 && parts[1] == "messages" && r.Method == http.MethodGet:
+		s.getMessage(w, r, parts[2])
+	case len(parts) == 3 && parts[1] == "messages" && parts[2] == "send" && r.Method == http.MethodPost:
+		s.sendMessage(w, r)
+	case len(parts) == 4 && parts[1] == "messages" && parts[3] == "modify" && r.Method == http.MethodPost:
+		s.modifyMessage(w, r, parts[2])
+	case len(parts) == 2 && parts[1] == "labels" && r.Method == http.MethodGet:
+		s.listLabels(w)
+	case len(parts) == 2 && parts[1] == "labels" && r.Method == http.MethodPost:
+		s.createLabel(w, r)
+	case len(parts) == 2 && parts[1] == "history" && r.Method == http.MethodGet:
+		s.listHistory(w, r)
+	case len(parts) == 3 && parts[1] == "threads" && r.Method == http.MethodGet:
+		s.getThread(w, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unsupported request %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
+	required, err := s.queryLabels(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	required = append(required, r.URL.Query()["labelIds"]...)
+
+	var matched []*gmail.Message
+	for _, id := range s.order {
+		msg := s.messages[id]
+		if hasLabels(msg, required) {
+			matched = append(matched, stub(msg))
+		}
+	}
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + pageSize
+	if max, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && max > 0 && max < pageSize {
+		end = start + max
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	resp := &gmail.ListMessagesResponse{
+		Messages:           matched[start:end],
+		ResultSizeEstimate: int64(len(matched)),
+	}
+	if end < len(matched) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	writeJSON(w, resp)
+}
+
+// getMessage returns the message, without its body for format=metadata.
+// format=raw is only supported for sent messages, whose raw form is kept.
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request, id string) {
+	msg, exists := s.messages[id]
+	if !exists {
+		writeError(w, http.StatusNotFound, "message not found: "+id)
+		return
+	}
+	if r.URL.Query().Get("format") == "raw" {
+		raw, exists := s.raw[id]
+		if !exists {
+			writeError(w, http.StatusBadRequest, "format=raw is only supported for sent messages")
+			return
+		}
+		writeJSON(w, &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId, LabelIds: msg.LabelIds, Raw: base64.URLEncoding.EncodeToString(raw)})
+		return
+	}
+	if r.URL.Query().Get("format") == "metadata" {
+		metadata := *msg
+		metadata.Payload = &gmail.MessagePart{MimeType: msg.Payload.MimeType, Headers: msg.Payload.Headers}
+		msg = &metadata
+	}
+	writeJSON(w, msg)
+}
+
+// sendMessage stores the raw RFC 2822 message as a SENT message with its
+// headers and decoded body, like a message sent from the Gmail web client.
+// A message to the account's own address also lands in the inbox.
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
+	var req gmail.Message
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	raw, err := base64.URLEncoding.DecodeString(req.Raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid raw message: "+err.Error())
+		return
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid raw message: "+err.Error())
+		return
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.EqualFold(parsed.Header.Get("Content-Transfer-Encoding"), "base64") {
+		body, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid message body: "+err.Error())
+			return
+		}
+	}
+
+	m := Message{
+		From:    "me",
+		Subject: parsed.Header.Get("Subject"),
+		Body:    string(body),
+		Headers: make(map[string]string),
+		Labels:  []string{"SENT"},
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(m.Subject); err == nil {
+		m.Subject = subject
+	}
+	for name, values := range parsed.Header {
+		if name != "Subject" {
+			m.Headers[name] = values[0]
+		}
+	}
+
+	if to, err := mail.ParseAddress(parsed.Header.Get("To")); err == nil && s.address != "" && strings.EqualFold(to.Address, s.address) {
+		m.Labels = append(m.Labels, "INBOX", "UNREAD")
+	}
+
+	id := s.addMessage(m)
+	s.raw[id] = raw
+	writeJSON(w, stub(s.messages[id]))
+}
+
+// getThread returns every message in the thread, in the order they were
+// added, as stubs like format=minimal.
+func (s *Server) getThread(w http.ResponseWriter, id string) {
+	thread := &gmail.Thread{Id: id}
+	for _, messageID := range s.order {
+		if msg := s.messages[messageID]; msg.ThreadId == id {
+			thread.Messages = append(thread.Messages, stub(msg))
+		}
+	}
+	if len(thread.Messages) == 0 {
+		writeError(w, http.StatusNotFound, "thread not found: "+id)
+		return
+	}
+	writeJSON(w, thread)
+}
+
+func (s *Server) modifyMessage(w http.ResponseWriter, r *http.Request, id string) {
+	msg, exists := s.messages[id]
+	if !exists {
+		writeError(w, http.StatusNotFound, "message not found: "+id)
+		return
+	}
+
+	var req gmail.ModifyMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, labelID := range append(append([]string(nil), req.AddLabelIds...), req.RemoveLabelIds...) {
+		if _, ok := s.labels[labelID]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid label: "+labelID)
+			return
+		}
+	}
+
+	var added, removed []string
+	for _, labelID := range req.AddLabelIds {
+		if !hasLabels(msg, []string{labelID}) {
+			msg.LabelIds = append(msg.LabelIds, labelID)
+			added = append(added, labelID)
+		}
+	}
+	for _, labelID := range req.RemoveLabelIds {
+		for i, existing := range msg.LabelIds {
+			if existing == labelID {
+				msg.LabelIds = append(msg.LabelIds[:i], msg.LabelIds[i+1:]...)
+				removed = append(removed, labelID)
+				break
+			}
+		}
+	}
+
+	history := &gmail.History{}
+	if len(added) > 0 {
+		history.LabelsAdded = []*gmail.HistoryLabelAdded{{LabelIds: added, Message: stub(msg)}}
+	}
+	if len(removed) > 0 {
+		history.LabelsRemoved = []*gmail.HistoryLabelRemoved{{LabelIds: removed, Message: stub(msg)}}
+	}
+	if len(added)+len(removed) > 0 {
+		s.recordHistory(history)
+	}
+
+	writeJSON(w, msg)
+}
+
+func (s *Server) listLabels(w http.ResponseWriter) {
+	resp := &gmail.ListLabelsResponse{}
+	for _, label := range s.labels {
+		resp.Labels = append(resp.Labels, label)
+	}
+	sort.Slice(resp.Labels, func(i, j int) bool { return resp.Labels[i].Id < resp.Labels[j].Id })
+	writeJSON(w, resp)
+}
+
+func (s *Server) createLabel(w http.ResponseWriter, r *http.Request) {
+	var label gmail.Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, existing := range s.labels {
+		if existing.Name == label.Name {
+			writeError(w, http.StatusConflict, "Label name exists or conflicts")
+			return
+		}
+	}
+
+	label.Id = fmt.Sprintf("Label_%d", len(s.labels)+1)
+	label.Type = "user"
+	s.labels[label.Id] = &label
+	writeJSON(w, &label)
+}
+
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request) {
+	startID, err := strconv.ParseUint(r.URL.Query().Get("startHistoryId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "startHistoryId is required")
+		return
+	}
+
+	resp := &gmail.ListHistoryResponse{HistoryId: s.historyID}
+	for _, h := range s.history {
+		if h.Id > startID {
+			resp.History = append(resp.History, h)
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) recordHistory(h *gmail.History) {
+	s.historyID++
+	h.Id = s.historyID
+	for _, m := range h.MessagesAdded {
+		h.Messages = append(h.Messages, m.Message)
+	}
+	for _, l := range h.LabelsAdded {
+		h.Messages = append(h.Messages, l.Message)
+	}
+	for _, l := range h.LabelsRemoved {
+		h.Messages = append(h.Messages, l.Message)
+	}
+	s.history = append(s.history, h)
+}
+
+// queryLabels supports the label-based subset of Gmail search used by the
+// tool: "in:inbox", "is:unread", "label:Name" terms joined by spaces.
+func (s *Server) queryLabels(q string) ([]string, error) {
+	var required []string
+	for _, term := range strings.Fields(q) {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok {
+			return nil, fmt.Errorf("unsupported search term %q", term)
+		}
+		switch key {
+		case "in", "is":
+			required = append(required, strings.ToUpper(value))
+		case "label":
+			id := ""
+			for _, label := range s.labels {
+				if strings.EqualFold(label.Name, value) || strings.EqualFold(label.Id, value) {
+					id = label.Id
+				}
+			}
+			if id == "" {
+				return nil, fmt.Errorf("unknown label %q", value)
+			}
+			required = append(required, id)
+		default:
+			return nil, fmt.Errorf("unsupported search term %q", term)
+		}
+	}
+	return required, nil
+}
+
+func hasLabels(msg *gmail.Message, labelIDs []string) bool {
+	for _, want := range labelIDs {
+		found := false
+		for _, have := range msg.LabelIds {
+			if have == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func stub(msg *gmail.Message) *gmail.Message {
+	return &gmail.Message{
+		Id:       msg.Id,
+		ThreadId: msg.ThreadId,
+		LabelIds: append([]string(nil), msg.LabelIds...),
+	}
+}
+
+func snippet(body string) string {
+	if len(body) > 100 {
+		return body[:100]
+	}
+	return body
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
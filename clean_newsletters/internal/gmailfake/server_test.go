@@ -0,0 +1,255 @@
+package gmailfake
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func newTestService(t *testing.T) (*gmail.Service, *Server) {
+	t.Helper()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	service, err := gmail.NewService(context.Background(), srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, srv
+}
+
+func statusCode(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+func TestListMessages(t *testing.T) {
+	service, srv := newTestService(t)
+	first := srv.AddMessage(Message{From: "a@example.com", Subject: "One"})
+	srv.AddMessage(Message{From: "b@example.com", Subject: "Two", Labels: []string{"INBOX"}})
+	srv.AddMessage(Message{From: "c@example.com", Subject: "Archived", Labels: []string{"STARRED"}})
+
+	inbox, err := service.Users.Messages.List("me").Q("in:inbox").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox.Messages) != 2 || inbox.Messages[0].Id != first {
+		t.Errorf("in:inbox = %+v", inbox.Messages)
+	}
+
+	unread, err := service.Users.Messages.List("me").Q("in:inbox is:unread").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unread.Messages) != 1 || unread.Messages[0].Id != first {
+		t.Errorf("in:inbox is:unread = %+v", unread.Messages)
+	}
+
+	// Pages continue from the token
+	page, err := service.Users.Messages.List("me").Q("in:inbox").MaxResults(1).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.NextPageToken == "" {
+		t.Fatalf("first page = %+v", page)
+	}
+	page, err = service.Users.Messages.List("me").Q("in:inbox").MaxResults(1).PageToken(page.NextPageToken).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Id == first || page.NextPageToken != "" {
+		t.Errorf("second page = %+v", page)
+	}
+
+	if _, err := service.Users.Messages.List("me").Q("from:a@example.com").Do(); statusCode(err) != http.StatusBadRequest {
+		t.Errorf("unsupported query = %v, want 400", err)
+	}
+}
+
+func TestGetMessage(t *testing.T) {
+	service, srv := newTestService(t)
+	id := srv.AddMessage(Message{
+		From:    "Weekly <news@example.com>",
+		Subject: "Issue 1",
+		Body:    "Hello",
+		Headers: map[string]string{"List-Id": "<weekly.example.com>"},
+	})
+
+	msg, err := service.Users.Messages.Get("me", id).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := make(map[string]string)
+	for _, h := range msg.Payload.Headers {
+		headers[h.Name] = h.Value
+	}
+	if headers["From"] != "Weekly <news@example.com>" || headers["Subject"] != "Issue 1" || headers["List-Id"] != "<weekly.example.com>" {
+		t.Errorf("headers = %v", headers)
+	}
+	body, err := base64.URLEncoding.DecodeString(msg.Payload.Body.Data)
+	if err != nil || string(body) != "Hello" {
+		t.Errorf("body = %q, %v", body, err)
+	}
+
+	if _, err := service.Users.Messages.Get("me", "missing").Do(); statusCode(err) != http.StatusNotFound {
+		t.Errorf("missing message = %v, want 404", err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	service, _ := newTestService(t)
+
+	label, err := service.Users.Labels.Create("me", &gmail.Label{Name: "Newsletter"}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label.Id == "" || label.Type != "user" {
+		t.Errorf("created label = %+v", label)
+	}
+	if _, err := service.Users.Labels.Create("me", &gmail.Label{Name: "Newsletter"}).Do(); statusCode(err) != http.StatusConflict {
+		t.Errorf("duplicate label = %v, want 409", err)
+	}
+
+	labels, err := service.Users.Labels.List("me").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, l := range labels.Labels {
+		if l.Name == "Newsletter" && l.Id == label.Id {
+			found = true
+		}
+	}
+	if !found || len(labels.Labels) != len(systemLabels)+1 {
+		t.Errorf("labels = %d, Newsletter found = %t", len(labels.Labels), found)
+	}
+}
+
+func TestModifyMessageRecordsHistory(t *testing.T) {
+	service, srv := newTestService(t)
+	id := srv.AddMessage(Message{From: "a@example.com", Subject: "One"})
+	label, err := service.Users.Labels.Create("me", &gmail.Label{Name: "Newsletter"}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := srv.HistoryID()
+
+	_, err = service.Users.Messages.Modify("me", id, &gmail.ModifyMessageRequest{
+		AddLabelIds:    []string{label.Id},
+		RemoveLabelIds: []string{"INBOX"},
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(srv.LabelNames(id)); got != "[Newsletter UNREAD]" {
+		t.Errorf("labels after modify = %s", got)
+	}
+
+	_, err = service.Users.Messages.Modify("me", id, &gmail.ModifyMessageRequest{AddLabelIds: []string{"Label_99"}}).Do()
+	if statusCode(err) != http.StatusBadRequest {
+		t.Errorf("unknown label = %v, want 400", err)
+	}
+
+	history, err := service.Users.History.List("me").StartHistoryId(start).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.History) != 1 || history.HistoryId != srv.HistoryID() {
+		t.Fatalf("history = %+v", history)
+	}
+	h := history.History[0]
+	if len(h.LabelsAdded) != 1 || h.LabelsAdded[0].LabelIds[0] != label.Id ||
+		len(h.LabelsRemoved) != 1 || h.LabelsRemoved[0].LabelIds[0] != "INBOX" {
+		t.Errorf("history entry = %+v", h)
+	}
+
+	if _, err := service.Users.History.List("me").Do(); statusCode(err) != http.StatusBadRequest {
+		t.Errorf("history without startHistoryId = %v, want 400", err)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	service, srv := newTestService(t)
+	srv.SetAddress("me@example.com")
+
+	body := base64.StdEncoding.EncodeToString([]byte("<p>Hello</p>"))
+	raw := "To: Me <me@example.com>\r\n" +
+		"Subject: =?utf-8?q?Caf=C3=A9?=\r\n" +
+		"X-Test: 1\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		body[:8] + "\r\n" + body[8:] + "\r\n"
+	sent, err := service.Users.Messages.Send("me", &gmail.Message{Raw: base64.URLEncoding.EncodeToString([]byte(raw))}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A message to the account itself is also delivered to the inbox
+	if got := fmt.Sprint(srv.LabelNames(sent.Id)); got != "[INBOX SENT UNREAD]" {
+		t.Errorf("labels = %s", got)
+	}
+	messages := srv.Sent()
+	if len(messages) != 1 {
+		t.Fatalf("sent = %+v", messages)
+	}
+	m := messages[0]
+	if m.ID != sent.Id || m.Subject != "Café" || m.Body != "<p>Hello</p>" || m.Headers["X-Test"] != "1" || m.Headers["To"] != "Me <me@example.com>" {
+		t.Errorf("sent message = %+v", m)
+	}
+
+	full, err := service.Users.Messages.Get("me", sent.Id).Format("raw").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := base64.URLEncoding.DecodeString(full.Raw); err != nil || string(decoded) != raw {
+		t.Errorf("raw = %q, %v", decoded, err)
+	}
+
+	// Mail to anyone else only shows as sent
+	other, err := service.Users.Messages.Send("me", &gmail.Message{
+		Raw: base64.URLEncoding.EncodeToString([]byte("To: you@example.com\r\nSubject: Hi\r\n\r\nHi\r\n")),
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(srv.LabelNames(other.Id)); got != "[SENT]" {
+		t.Errorf("labels of mail to someone else = %s", got)
+	}
+
+	if _, err := service.Users.Messages.Send("me", &gmail.Message{Raw: "not base64!"}).Do(); statusCode(err) != http.StatusBadRequest {
+		t.Errorf("invalid raw message = %v, want 400", err)
+	}
+	id := srv.AddMessage(Message{From: "a@example.com", Subject: "One"})
+	if _, err := service.Users.Messages.Get("me", id).Format("raw").Do(); statusCode(err) != http.StatusBadRequest {
+		t.Errorf("raw format of a received message = %v, want 400", err)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	_, srv := newTestService(t)
+	ids, err := srv.LoadFixtures([]byte(`[{"id": "a", "from": "a@example.com"}, {"from": "b@example.com", "labels": ["INBOX"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "a" {
+		t.Fatalf("ids = %v", ids)
+	}
+	if got := fmt.Sprint(srv.LabelNames(ids[0])); got != "[INBOX UNREAD]" {
+		t.Errorf("default labels = %s", got)
+	}
+	if got := fmt.Sprint(srv.LabelNames(ids[1])); got != "[INBOX]" {
+		t.Errorf("fixture labels = %s", got)
+	}
+
+	if _, err := srv.LoadFixtures([]byte(`{`)); err == nil {
+		t.Errorf("invalid fixtures loaded")
+	}
+}
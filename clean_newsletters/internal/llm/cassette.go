@@ -0,0 +1,273 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// CassetteRecord sends every request to the model and records it.
+	CassetteRecord = "record"
+	// CassetteReplay answers recorded requests from the cassette and sends
+	// (and records) the rest.
+	CassetteReplay = "replay"
+	// CassetteStrict answers only from the cassette and fails on anything
+	// unrecorded.
+	CassetteStrict = "strict"
+)
+
+// ErrNotRecorded is returned in strict mode for requests missing from the
+// cassette.
+var ErrNotRecorded = errors.New("no recorded LLM response")
+
+// Interaction is one request/response pair, stored as a line of the
+// cassette file. Schema is the name of the requested schema, if any.
+type Interaction struct {
+	Key      string    `json:"key"`
+	Model    string    `json:"model"`
+	Schema   string    `json:"schema,omitempty"`
+	Messages []Message `json:"messages"`
+	Response string    `json:"response"`
+	Usage    Usage     `json:"usage"`
+}
+
+// InteractionKey identifies a request by model and messages and, for
+// structured requests, the response format and schema, since changing
+// either changes the reply.
+func InteractionKey(model, format string, messages []Message, schema *Schema) string {
+	if schema == nil {
+		format = ""
+	}
+	data, _ := json.Marshal(struct {
+		Model    string    `json:"model"`
+		Format   string    `json:"format,omitempty"`
+		Messages []Message `json:"messages"`
+		Schema   *Schema   `json:"schema,omitempty"`
+	}{model, format, messages, schema})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Cassette wraps a Completer so runs can be recorded and replayed without
+// network access. Replayed requests report the usage recorded with them, so
+// token and cost figures stay comparable between live and replayed runs.
+type Cassette struct {
+	next   Completer
+	model  string
+	format string
+	mode   string
+
+	mu           sync.Mutex
+	file         *os.File
+	interactions map[string]*Interaction
+	usage        Usage
+}
+
+// NewCassette opens or creates the cassette at path. format is the
+// response format next uses for structured requests (FormatJSONSchema when
+// empty). next may be nil in strict mode, where no request is ever sent.
+// Record mode appends to an existing cassette; when a request was recorded
+// more than once, the last recording is replayed.
+func NewCassette(next Completer, model, format, path, mode string) (*Cassette, error) {
+	switch mode {
+	case CassetteRecord, CassetteReplay, CassetteStrict:
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q (expected %s, %s or %s)", mode, CassetteRecord, CassetteReplay, CassetteStrict)
+	}
+	if next == nil && mode != CassetteStrict {
+		return nil, fmt.Errorf("cassette mode %s needs an LLM client", mode)
+	}
+
+	if format == "" {
+		format = FormatJSONSchema
+	}
+
+	c := &Cassette{
+		next:         next,
+		model:        model,
+		format:       format,
+		mode:         mode,
+		interactions: make(map[string]*Interaction),
+	}
+
+	if mode != CassetteRecord {
+		if err := c.load(path); err != nil {
+			if !os.IsNotExist(err) || mode == CassetteStrict {
+				return nil, err
+			}
+		}
+	}
+
+	if mode != CassetteStrict {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open cassette %s: %v", path, err)
+		}
+		c.file = f
+	}
+
+	return c, nil
+}
+
+func (c *Cassette) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		interaction := &Interaction{}
+		if err := json.Unmarshal(scanner.Bytes(), interaction); err != nil {
+			return fmt.Errorf("invalid interaction on line %d of cassette %s: %v", line, path, err)
+		}
+		c.interactions[interaction.Key] = interaction
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read cassette %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func (c *Cassette) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+func (c *Cassette) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.play(prompt, nil, func() (string, error) {
+		return c.next.Complete(ctx, prompt)
+	})
+}
+
+func (c *Cassette) CompleteJSON(ctx context.Context, prompt string, schema *Schema) (string, error) {
+	return c.play(prompt, schema, func() (string, error) {
+		return c.next.CompleteJSON(ctx, prompt, schema)
+	})
+}
+
+// Usage combines replayed usage with whatever the wrapped client spent.
+func (c *Cassette) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.usage
+}
+
+func (c *Cassette) play(prompt string, schema *Schema, send func() (string, error)) (string, error) {
+	messages := PromptMessages(prompt)
+	key := InteractionKey(c.model, c.format, messages, schema)
+
+	c.mu.Lock()
+	interaction, ok := c.interactions[key]
+	c.mu.Unlock()
+
+	if ok && c.mode != CassetteRecord {
+		c.mu.Lock()
+		c.usage.Add(interaction.Usage)
+		c.mu.Unlock()
+		return interaction.Response, nil
+	}
+	if c.mode == CassetteStrict {
+		return "", fmt.Errorf("%w for model %s (key %s)", ErrNotRecorded, c.model, key[:12])
+	}
+
+	before := c.next.Usage()
+	response, err := send()
+	spent := c.next.Usage()
+	spent.Requests -= before.Requests
+	spent.PromptTokens -= before.PromptTokens
+	spent.CompletionTokens -= before.CompletionTokens
+	spent.TotalTokens -= before.TotalTokens
+	spent.Cost -= before.Cost
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usage.Add(spent)
+	if err != nil {
+		return "", err
+	}
+
+	interaction = &Interaction{
+		Key:      key,
+		Model:    c.model,
+		Messages: messages,
+		Response: response,
+		Usage:    spent,
+	}
+	if schema != nil {
+		interaction.Schema = schema.Name
+	}
+	err = c.store(interaction)
+	if err != nil {
+		return "", err
+	}
+
+	return response, nil
+}
+
+// store adds an interaction and appends it to the cassette file. c.mu must
+// be held.
+func (c *Cassette) store(interaction *Interaction) error {
+	c.interactions[interaction.Key] = interaction
+
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("unable to write cassette: %v", err)
+	}
+	return nil
+}
+
+// ContextWindow replays the model's context window, recorded once as an
+// interaction without messages, so a replayed run plans the same batches
+// as the recorded one.
+func (c *Cassette) ContextWindow(ctx context.Context) (int, error) {
+	key := InteractionKey(c.model, "", nil, nil)
+
+	c.mu.Lock()
+	interaction, ok := c.interactions[key]
+	c.mu.Unlock()
+
+	if ok && c.mode != CassetteRecord {
+		return strconv.Atoi(interaction.Response)
+	}
+	if c.mode == CassetteStrict {
+		return 0, fmt.Errorf("%w: context window of model %s", ErrNotRecorded, c.model)
+	}
+
+	windower, ok := c.next.(ContextWindower)
+	if !ok {
+		return 0, fmt.Errorf("LLM client cannot look up the context window")
+	}
+	window, err := windower.ContextWindow(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.store(&Interaction{Key: key, Model: c.model, Response: strconv.Itoa(window)}); err != nil {
+		return 0, err
+	}
+	return window, nil
+}
@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// countingCompleter replies with the request number, so every live request
+// gets a distinct answer.
+type countingCompleter struct {
+	requests int
+}
+
+func (c *countingCompleter) Complete(ctx context.Context, prompt string) (string, error) {
+	c.requests++
+	return fmt.Sprintf("reply %d", c.requests), nil
+}
+
+func (c *countingCompleter) CompleteJSON(ctx context.Context, prompt string, schema *Schema) (string, error) {
+	return c.Complete(ctx, prompt)
+}
+
+func (c *countingCompleter) Usage() Usage {
+	return Usage{Requests: c.requests, TotalTokens: 10 * c.requests}
+}
+
+var testSchema = &Schema{Name: "answer", Schema: map[string]interface{}{"type": "object"}}
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	live := &countingCompleter{}
+	recorder, err := NewCassette(live, "test/model", "", path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := recorder.Complete(ctx, "hello")
+	structured, _ := recorder.CompleteJSON(ctx, "hello", testSchema)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if plain == structured {
+		t.Fatalf("plain and structured requests shared a reply")
+	}
+
+	replay, err := NewCassette(nil, "test/model", "", path, CassetteStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+
+	if got, err := replay.Complete(ctx, "hello"); err != nil || got != plain {
+		t.Errorf("replayed Complete = %q, %v, want %q", got, err, plain)
+	}
+	if got, err := replay.CompleteJSON(ctx, "hello", testSchema); err != nil || got != structured {
+		t.Errorf("replayed CompleteJSON = %q, %v, want %q", got, err, structured)
+	}
+	if usage := replay.Usage(); usage.Requests != 2 || usage.TotalTokens != 20 {
+		t.Errorf("replayed usage = %+v, want the recorded 2 requests and 20 tokens", usage)
+	}
+
+	if _, err := replay.Complete(ctx, "goodbye"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("unrecorded prompt = %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestCassetteKeyIncludesSchemaAndFormat(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	recorder, err := NewCassette(&countingCompleter{}, "test/model", FormatJSONSchema, path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.CompleteJSON(ctx, "hello", testSchema)
+	recorder.Close()
+
+	otherSchema := &Schema{Name: "answer", Schema: map[string]interface{}{"type": "object", "required": []string{"x"}}}
+	replay, err := NewCassette(nil, "test/model", FormatJSONSchema, path, CassetteStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replay.CompleteJSON(ctx, "hello", otherSchema); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("changed schema = %v, want ErrNotRecorded", err)
+	}
+	replay.Close()
+
+	replay, err = NewCassette(nil, "test/model", FormatJSONObject, path, CassetteStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	if _, err := replay.CompleteJSON(ctx, "hello", testSchema); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("changed response format = %v, want ErrNotRecorded", err)
+	}
+	// Plain requests send no response format, so it does not matter
+	if InteractionKey("test/model", FormatJSONObject, PromptMessages("hi"), nil) != InteractionKey("test/model", FormatNone, PromptMessages("hi"), nil) {
+		t.Errorf("response format changed the key of a plain request")
+	}
+}
+
+func TestCassetteRecordAppends(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	first, err := NewCassette(&countingCompleter{}, "test/model", "", path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Complete(ctx, "one")
+	first.Close()
+
+	// Recording again keeps earlier recordings and re-records repeated
+	// requests
+	live := &countingCompleter{requests: 10}
+	second, err := NewCassette(live, "test/model", "", path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Complete(ctx, "two")
+	second.Complete(ctx, "one")
+	second.Close()
+	if live.requests != 12 {
+		t.Errorf("record mode sent %d requests, want 2", live.requests-10)
+	}
+
+	replay, err := NewCassette(nil, "test/model", "", path, CassetteStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	if got, err := replay.Complete(ctx, "two"); err != nil || got != "reply 11" {
+		t.Errorf("replayed two = %q, %v", got, err)
+	}
+	if got, err := replay.Complete(ctx, "one"); err != nil || got != "reply 12" {
+		t.Errorf("replayed one = %q, %v, want the newest recording", got, err)
+	}
+}
+
+func TestCassetteReplayRecordsMisses(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	live := &countingCompleter{}
+	cassette, err := NewCassette(live, "test/model", "", path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette.Complete(ctx, "hello")
+	cassette.Complete(ctx, "hello")
+	cassette.Close()
+	if live.requests != 1 {
+		t.Errorf("replay mode sent %d requests, want 1", live.requests)
+	}
+}
@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRateLimited   = errors.New("rate limited by LLM provider")
+	ErrAuth          = errors.New("LLM provider rejected the API key")
+	ErrNoCredits     = errors.New("LLM provider account is out of credits")
+	ErrContextLength = errors.New("prompt exceeds the model context length")
+)
+
+// contextLengthCode is the error code OpenAI-compatible servers return for
+// prompts longer than the context window.
+const contextLengthCode = "context_length_exceeded"
+
+// contextLengthMessages are how providers that report no error code word
+// the same failure in a 400 response.
+var contextLengthMessages = []string{
+	"maximum context length",
+	"context length exceeded",
+	"context_length_exceeded",
+	"exceeds the context window",
+}
+
+// APIError is returned for unsuccessful responses. It unwraps to one of the
+// sentinel errors above when the failure has a known cause.
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	if e.kind != nil {
+		return fmt.Sprintf("%v (status %d): %s", e.kind, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// newAPIError classifies an unsuccessful response. code is the error code
+// from the response body, if any.
+func newAPIError(statusCode int, code, message string, header http.Header) *APIError {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Message:    message,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		apiErr.kind = ErrRateLimited
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		apiErr.kind = ErrAuth
+	case statusCode == http.StatusPaymentRequired:
+		apiErr.kind = ErrNoCredits
+	case isContextLength(statusCode, code, message):
+		apiErr.kind = ErrContextLength
+	}
+
+	return apiErr
+}
+
+// isContextLength recognises an over-long prompt by its error code or
+// status, falling back to the message only for plain 400 responses.
+func isContextLength(statusCode int, code, message string) bool {
+	if code == contextLengthCode || statusCode == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	if statusCode != http.StatusBadRequest {
+		return false
+	}
+	lower := strings.ToLower(message)
+	for _, phrase := range contextLengthMessages {
+		if strings.Contains(lower, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
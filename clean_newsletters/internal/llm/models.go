@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ContextWindower is implemented by clients that can look up the context
+// window of their model.
+type ContextWindower interface {
+	ContextWindow(ctx context.Context) (int, error)
+}
+
+// ContextWindow returns the model's context length in tokens as listed by
+// the endpoint's /models API. The answer is cached for the life of the
+// client.
+func (c *OpenRouterClient) ContextWindow(ctx context.Context) (int, error) {
+	c.windowMu.Lock()
+	defer c.windowMu.Unlock()
+	if c.window > 0 {
+		return c.window, nil
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/models", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, &transportError{err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(resp.StatusCode, "", strings.TrimSpace(string(body)), resp.Header)
+	}
+
+	var models struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &models); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal model list: %v", err)
+	}
+
+	// Variants such as ":free" share the base model's window
+	base, _, _ := strings.Cut(c.model, ":")
+	for _, model := range models.Data {
+		if model.ContextLength > 0 && (model.ID == c.model || model.ID == base) {
+			c.window = model.ContextLength
+			if model.ID == c.model {
+				break
+			}
+		}
+	}
+	if c.window == 0 {
+		return 0, fmt.Errorf("no context length listed for model %s", c.model)
+	}
+	return c.window, nil
+}
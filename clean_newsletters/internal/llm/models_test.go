@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func newModelsServer(t *testing.T, requests *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		w.Write([]byte(`{"data": [
+			{"id": "vendor/small", "context_length": 8192},
+			{"id": "vendor/large", "context_length": 131072},
+			{"id": "vendor/large:free", "context_length": 32768}
+		]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestContextWindow(t *testing.T) {
+	var requests int32
+	srv := newModelsServer(t, &requests)
+	ctx := context.Background()
+
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"vendor/large", 131072},
+		{"vendor/large:free", 32768},
+		// Variants without their own entry use the base model's
+		{"vendor/large:nitro", 131072},
+		{"vendor/unknown", 0},
+	}
+	for _, tt := range tests {
+		client := NewOpenRouterClient(srv.URL, "key", tt.model, Options{})
+		got, err := client.ContextWindow(ctx)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("ContextWindow(%s) = %d, want an error", tt.model, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ContextWindow(%s) = %d, %v, want %d", tt.model, got, err, tt.want)
+		}
+	}
+
+	client := NewOpenRouterClient(srv.URL, "key", "vendor/small", Options{})
+	before := atomic.LoadInt32(&requests)
+	client.ContextWindow(ctx)
+	client.ContextWindow(ctx)
+	if n := atomic.LoadInt32(&requests) - before; n != 1 {
+		t.Errorf("looked up the model list %d times, want 1", n)
+	}
+}
+
+func TestCassetteContextWindow(t *testing.T) {
+	var requests int32
+	srv := newModelsServer(t, &requests)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	live := NewOpenRouterClient(srv.URL, "key", "vendor/large", Options{})
+	recorder, err := NewCassette(live, "vendor/large", "", path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if window, err := recorder.ContextWindow(ctx); err != nil || window != 131072 {
+		t.Fatalf("recorded ContextWindow = %d, %v", window, err)
+	}
+	recorder.Close()
+
+	replay, err := NewCassette(nil, "vendor/large", "", path, CassetteStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	before := atomic.LoadInt32(&requests)
+	if window, err := replay.ContextWindow(ctx); err != nil || window != 131072 {
+		t.Errorf("replayed ContextWindow = %d, %v", window, err)
+	}
+	if atomic.LoadInt32(&requests) != before {
+		t.Errorf("strict replay asked the endpoint")
+	}
+}
@@ -4,15 +4,81 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+const DefaultBaseURL = "https://openrouter.ai/api/v1"
+
+const (
+	baseBackoff   = time.Second
+	maxBackoff    = 30 * time.Second
+	maxRetryAfter = 5 * time.Minute
+)
+
+const (
+	FormatJSONSchema = "json_schema"
+	FormatJSONObject = "json_object"
+	FormatNone       = "none"
+)
+
+// Completer is implemented by every LLM backend the processor can use.
+// CompleteJSON asks for a reply matching the schema; callers must still
+// parse defensively because not every backend enforces it.
+type Completer interface {
+	Complete(ctx context.Context, prompt string) (string, error)
+	CompleteJSON(ctx context.Context, prompt string, schema *Schema) (string, error)
+	Usage() Usage
+}
+
+// Schema is a named JSON schema for structured output.
+type Schema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// Options controls request timeouts, retries and client-side rate limiting.
+// Zero values disable the corresponding behaviour.
+type Options struct {
+	Timeout           time.Duration
+	MaxRetries        int
+	RequestsPerMinute int
+	// ResponseFormat is the structured output mode the backend supports:
+	// FormatJSONSchema (default), FormatJSONObject or FormatNone.
+	ResponseFormat string
+	// Prices estimates cost when the provider does not report it.
+	Prices PriceTable
+	// BudgetUSD stops further requests once the run's cost exceeds it.
+	BudgetUSD float64
+}
+
+// OpenRouterClient talks to OpenRouter or any other OpenAI-compatible chat
+// completions endpoint (Ollama, llama.cpp server, vLLM, ...).
 type OpenRouterClient struct {
-	apiKey string
-	model  string
-	client *http.Client
+	baseURL    string
+	apiKey     string
+	model      string
+	client     *http.Client
+	timeout    time.Duration
+	maxRetries int
+	limiter    *tokenBucket
+
+	formatMu       sync.Mutex
+	responseFormat string
+
+	prices    PriceTable
+	budgetUSD float64
+	usageMu   sync.Mutex
+	usage     Usage
+
+	windowMu sync.Mutex
+	window   int
 }
 
 type Message struct {
@@ -21,71 +87,269 @@ type Message struct {
 }
 
 type ChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Usage          *UsageRequest   `json:"usage,omitempty"`
+}
+
+// UsageRequest asks OpenRouter to include the request cost in the response.
+type UsageRequest struct {
+	Include bool `json:"include"`
+}
+
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string                 `json:"name"`
+	Strict bool                   `json:"strict"`
+	Schema map[string]interface{} `json:"schema"`
 }
 
 type ChatResponse struct {
 	Choices []struct {
 		Message Message `json:"message"`
 	} `json:"choices"`
-	Error *struct {
-		Message string `json:"message"`
-	} `json:"error,omitempty"`
+	Usage *struct {
+		PromptTokens     int      `json:"prompt_tokens"`
+		CompletionTokens int      `json:"completion_tokens"`
+		TotalTokens      int      `json:"total_tokens"`
+		Cost             *float64 `json:"cost,omitempty"`
+	} `json:"usage,omitempty"`
+	Error *ErrorBody `json:"error,omitempty"`
+}
+
+// ErrorBody is the error object of a failed response. Code is a number on
+// OpenRouter and a string such as "context_length_exceeded" elsewhere.
+type ErrorBody struct {
+	Message string      `json:"message"`
+	Code    interface{} `json:"code,omitempty"`
 }
 
-func NewOpenRouterClient(apiKey, model string) *OpenRouterClient {
+// code returns Code when it is a string.
+func (e *ErrorBody) code() string {
+	code, _ := e.Code.(string)
+	return code
+}
+
+func NewOpenRouterClient(baseURL, apiKey, model string, opts Options) *OpenRouterClient {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if opts.ResponseFormat == "" {
+		opts.ResponseFormat = FormatJSONSchema
+	}
+
 	return &OpenRouterClient{
-		apiKey: apiKey,
-		model:  model,
-		client: &http.Client{},
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		client:     &http.Client{},
+		timeout:    opts.Timeout,
+		maxRetries: opts.MaxRetries,
+		limiter:    newTokenBucket(opts.RequestsPerMinute),
+
+		responseFormat: opts.ResponseFormat,
+		prices:         opts.Prices,
+		budgetUSD:      opts.BudgetUSD,
 	}
 }
 
 func (c *OpenRouterClient) Complete(ctx context.Context, prompt string) (string, error) {
+	return c.complete(ctx, c.newRequest(prompt, nil))
+}
+
+// CompleteJSON requests structured output using the configured response
+// format. Backends that reject response_format are downgraded to plain
+// prompting for the rest of the run.
+func (c *OpenRouterClient) CompleteJSON(ctx context.Context, prompt string, schema *Schema) (string, error) {
+	content, err := c.complete(ctx, c.newRequest(prompt, schema))
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(apiErr.Message), "response_format") && c.format() != FormatNone {
+		c.formatMu.Lock()
+		c.responseFormat = FormatNone
+		c.formatMu.Unlock()
+		return c.complete(ctx, c.newRequest(prompt, schema))
+	}
+
+	return content, err
+}
+
+func (c *OpenRouterClient) Usage() Usage {
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+
+	return c.usage
+}
+
+func (c *OpenRouterClient) checkBudget() error {
+	if c.budgetUSD <= 0 {
+		return nil
+	}
+
+	usage := c.Usage()
+	if usage.Cost >= c.budgetUSD {
+		return fmt.Errorf("%w: spent $%.4f of $%.4f", ErrBudgetExceeded, usage.Cost, c.budgetUSD)
+	}
+	return nil
+}
+
+func (c *OpenRouterClient) format() string {
+	c.formatMu.Lock()
+	defer c.formatMu.Unlock()
+
+	return c.responseFormat
+}
+
+func (c *OpenRouterClient) newRequest(prompt string, schema *Schema) ChatRequest {
 	request := ChatRequest{
-		Model: c.model,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
+		Model:    c.model,
+		Messages: PromptMessages(prompt),
+	}
+
+	if strings.Contains(c.baseURL, "openrouter.ai") {
+		request.Usage = &UsageRequest{Include: true}
+	}
+
+	if schema != nil {
+		switch c.format() {
+		case FormatJSONSchema:
+			request.ResponseFormat = &ResponseFormat{
+				Type: FormatJSONSchema,
+				JSONSchema: &JSONSchema{
+					Name:   schema.Name,
+					Strict: true,
+					Schema: schema.Schema,
+				},
+			}
+		case FormatJSONObject:
+			request.ResponseFormat = &ResponseFormat{Type: FormatJSONObject}
+		}
+	}
+
+	return request
+}
+
+// PromptMessages is the chat conversation sent for a prompt.
+func PromptMessages(prompt string) []Message {
+	return []Message{
+		{
+			Role:    "user",
+			Content: prompt,
 		},
 	}
+}
 
+// complete sends the request and returns the first choice. Rate limits,
+// server errors and timeouts are retried with exponential backoff and jitter,
+// honouring Retry-After when the provider sends it.
+func (c *OpenRouterClient) complete(ctx context.Context, request ChatRequest) (string, error) {
 	jsonData, err := json.Marshal(request)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(jsonData))
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff(attempt, lastErr)); err != nil {
+				return "", err
+			}
+		}
+
+		if err := c.checkBudget(); err != nil {
+			return "", err
+		}
+
+		if err := c.limiter.Wait(ctx); err != nil {
+			return "", err
+		}
+
+		content, err := c.send(ctx, jsonData)
+		if err == nil {
+			return content, nil
+		}
+		if ctx.Err() != nil || !isRetryable(err) {
+			return "", err
+		}
+		lastErr = err
+	}
+
+	return "", fmt.Errorf("giving up after %d attempts: %w", c.maxRetries+1, lastErr)
+}
+
+func (c *OpenRouterClient) send(ctx context.Context, jsonData []byte) (string, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("HTTP-Referer", "https://github.com/clean-newsletters")
 	req.Header.Set("X-Title", "Clean Newsletters")
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return "", &transportError{err: err}
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return "", &transportError{err: err}
 	}
 
 	var chatResp ChatResponse
-	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+	unmarshalErr := json.Unmarshal(body, &chatResp)
+
+	if resp.StatusCode != http.StatusOK {
+		message, code := strings.TrimSpace(string(body)), ""
+		if unmarshalErr == nil && chatResp.Error != nil {
+			message, code = chatResp.Error.Message, chatResp.Error.code()
+		}
+		return "", newAPIError(resp.StatusCode, code, message, resp.Header)
+	}
+
+	if unmarshalErr != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %v", unmarshalErr)
+	}
+
+	usage := Usage{Requests: 1}
+	if chatResp.Usage != nil {
+		usage.PromptTokens = chatResp.Usage.PromptTokens
+		usage.CompletionTokens = chatResp.Usage.CompletionTokens
+		usage.TotalTokens = chatResp.Usage.TotalTokens
+		if chatResp.Usage.Cost != nil {
+			usage.Cost = *chatResp.Usage.Cost
+		} else {
+			usage.Cost = c.prices.Cost(c.model, usage.PromptTokens, usage.CompletionTokens)
+		}
 	}
+	c.usageMu.Lock()
+	c.usage.Add(usage)
+	c.usageMu.Unlock()
 
+	// OpenRouter reports some upstream failures in the body of a 200 response.
 	if chatResp.Error != nil {
-		return "", fmt.Errorf("API error: %s", chatResp.Error.Message)
+		status := http.StatusBadGateway
+		if code, ok := chatResp.Error.Code.(float64); ok && code >= 400 {
+			status = int(code)
+		}
+		return "", newAPIError(status, chatResp.Error.code(), chatResp.Error.Message, resp.Header)
 	}
 
 	if len(chatResp.Choices) == 0 {
@@ -93,4 +357,43 @@ func (c *OpenRouterClient) Complete(ctx context.Context, prompt string) (string,
 	}
 
 	return chatResp.Choices[0].Message.Content, nil
-}
\ No newline at end of file
+}
+
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("failed to send request: %v", e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.retryable()
+	}
+	var transportErr *transportError
+	return errors.As(err, &transportErr)
+}
+
+// backoff returns the delay before the given retry attempt: the server's
+// Retry-After when present, otherwise full-jitter exponential backoff.
+func backoff(attempt int, lastErr error) time.Duration {
+	var apiErr *APIError
+	if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > maxRetryAfter {
+			return maxRetryAfter
+		}
+		return apiErr.RetryAfter
+	}
+
+	ceiling := baseBackoff << (attempt - 1)
+	if ceiling <= 0 || ceiling > maxBackoff {
+		ceiling = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling))) + baseBackoff/2
+}
@@ -0,0 +1,174 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 120 * time.Second, 120 * time.Second},
+		{" 5 ", 5 * time.Second, 5 * time.Second},
+		{"0", 0, 0},
+		{"-3", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v..%v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	retryAfter := &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second}
+	if got := backoff(1, retryAfter); got != 7*time.Second {
+		t.Errorf("backoff with Retry-After 7s = %v", got)
+	}
+	tooLong := &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}
+	if got := backoff(1, tooLong); got != maxRetryAfter {
+		t.Errorf("backoff with Retry-After 1h = %v, want %v", got, maxRetryAfter)
+	}
+
+	for attempt := 1; attempt <= 40; attempt++ {
+		ceiling := baseBackoff << (attempt - 1)
+		if ceiling <= 0 || ceiling > maxBackoff {
+			ceiling = maxBackoff
+		}
+		for i := 0; i < 20; i++ {
+			got := backoff(attempt, &APIError{StatusCode: http.StatusBadGateway})
+			if got < baseBackoff/2 || got > ceiling+baseBackoff/2 {
+				t.Fatalf("backoff(%d) = %v, want %v..%v", attempt, got, baseBackoff/2, ceiling+baseBackoff/2)
+			}
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+
+	var unlimited *tokenBucket
+	if err := unlimited.Wait(ctx); err != nil {
+		t.Fatalf("nil bucket: %v", err)
+	}
+
+	// 6000 requests a minute refill one token every 10ms
+	bucket := newTokenBucket(6000)
+	started := time.Now()
+	if err := bucket.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Millisecond {
+		t.Errorf("first request waited %v", elapsed)
+	}
+	for i := 0; i < 3; i++ {
+		if err := bucket.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(started); elapsed < 25*time.Millisecond {
+		t.Errorf("four requests took %v, want about 30ms of refill", elapsed)
+	}
+
+	// A slow bucket gives up when the context is done
+	slow := newTokenBucket(1)
+	slow.Wait(ctx)
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := slow.Wait(cancelled); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait on an expired context = %v", err)
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		status  int
+		code    string
+		message string
+		want    error
+	}{
+		{http.StatusTooManyRequests, "", "slow down", ErrRateLimited},
+		{http.StatusUnauthorized, "", "invalid key", ErrAuth},
+		{http.StatusPaymentRequired, "", "insufficient credits", ErrNoCredits},
+		{http.StatusBadRequest, "context_length_exceeded", "prompt too long", ErrContextLength},
+		{http.StatusRequestEntityTooLarge, "", "", ErrContextLength},
+		{http.StatusBadRequest, "", "This endpoint's maximum context length is 8192 tokens", ErrContextLength},
+		// Only a 400 is read for wording
+		{http.StatusInternalServerError, "", "maximum context length reached while loading", nil},
+		{http.StatusBadRequest, "", "invalid response_format", nil},
+	}
+	for _, tt := range tests {
+		err := newAPIError(tt.status, tt.code, tt.message, http.Header{})
+		if tt.want == nil {
+			if err.kind != nil {
+				t.Errorf("newAPIError(%d, %q, %q) = %v, want no kind", tt.status, tt.code, tt.message, err.kind)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("newAPIError(%d, %q, %q) = %v, want %v", tt.status, tt.code, tt.message, err, tt.want)
+		}
+	}
+}
+
+func TestCompleteDoesNotRetryPaymentRequired(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write([]byte(`{"error": {"code": 402, "message": "Insufficient credits"}}`))
+	}))
+	defer srv.Close()
+
+	client := NewOpenRouterClient(srv.URL, "key", "test/model", Options{MaxRetries: 3})
+	_, err := client.Complete(context.Background(), "hello")
+	if !errors.Is(err, ErrNoCredits) {
+		t.Fatalf("Complete = %v, want ErrNoCredits", err)
+	}
+	if errors.Is(err, ErrAuth) {
+		t.Errorf("402 reported as an auth failure: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestCompleteUsesBaseURL(t *testing.T) {
+	var path, authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "YES"}}]}`))
+	}))
+	defer srv.Close()
+
+	// Local endpoints get no Authorization header without a key
+	client := NewOpenRouterClient(srv.URL+"/v1/", "", "local-model", Options{})
+	got, err := client.Complete(context.Background(), "Is this a newsletter?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "YES" || path != "/v1/chat/completions" || authorization != "" {
+		t.Errorf("Complete = %q, path %q, Authorization %q", got, path, authorization)
+	}
+
+	client = NewOpenRouterClient(srv.URL+"/v1", "sk-test", "local-model", Options{})
+	if _, err := client.Complete(context.Background(), "Is this a newsletter?"); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer sk-test" {
+		t.Errorf("Authorization = %q", authorization)
+	}
+}
@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a client-side rate limiter. A nil bucket never waits.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	perSec   float64
+	last     time.Time
+}
+
+func newTokenBucket(requestsPerMinute int) *tokenBucket {
+	if requestsPerMinute <= 0 {
+		return nil
+	}
+
+	return &tokenBucket{
+		tokens:   1,
+		capacity: float64(requestsPerMinute),
+		perSec:   float64(requestsPerMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.perSec
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.perSec * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"clean_newsletters/internal/atomicfile"
+)
+
+var ErrBudgetExceeded = errors.New("LLM budget for this run exceeded")
+
+// Usage is the running total of requests, tokens and cost since the client
+// was created, i.e. for the current run. Cost is in US dollars: the
+// provider-reported cost when available, otherwise an estimate from the
+// price table.
+type Usage struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost_usd"`
+}
+
+func (u *Usage) Add(other Usage) {
+	u.Requests += other.Requests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// Price is the cost in US dollars per million tokens.
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// PriceTable maps model names to prices. The "*" entry applies to models
+// that are not listed.
+type PriceTable map[string]Price
+
+func LoadPriceTable(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read price table: %v", err)
+	}
+
+	var table PriceTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("unable to parse price table %s: %v", path, err)
+	}
+	return table, nil
+}
+
+func (t PriceTable) Cost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := t[model]
+	if !ok {
+		price = t["*"]
+	}
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1e6
+}
+
+// RunUsage is the usage of a single run as persisted in the usage log.
+type RunUsage struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Model    string    `json:"model"`
+	Usage    Usage     `json:"usage"`
+}
+
+// UsageLog is the per-profile history of LLM usage.
+type UsageLog struct {
+	mu       sync.Mutex
+	filePath string
+	Total    Usage      `json:"total"`
+	Runs     []RunUsage `json:"runs"`
+}
+
+func LoadUsageLog(filePath string) (*UsageLog, error) {
+	log := &UsageLog{filePath: filePath}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return log, nil
+		}
+		return nil, fmt.Errorf("unable to read usage log: %v", err)
+	}
+
+	if err := json.Unmarshal(data, log); err != nil {
+		return nil, fmt.Errorf("unable to parse usage log %s: %v", filePath, err)
+	}
+	return log, nil
+}
+
+func (l *UsageLog) Record(run RunUsage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.Runs = append(l.Runs, run)
+	l.Total.Add(run.Usage)
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.filePath), 0700); err != nil {
+		return fmt.Errorf("unable to create usage log directory: %v", err)
+	}
+	return atomicfile.Write(l.filePath, data)
+}
@@ -0,0 +1,40 @@
+package llm
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageLogRecord(t *testing.T) {
+	// The profile directory may not exist yet on a first run
+	path := filepath.Join(t.TempDir(), "profile", "llm_usage.json")
+	log, err := LoadUsageLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := []Usage{
+		{Requests: 2, PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120, Cost: 0.01},
+		{Requests: 1, PromptTokens: 50, CompletionTokens: 10, TotalTokens: 60, Cost: 0.005},
+	}
+	for _, usage := range runs {
+		err := log.Record(RunUsage{Started: time.Now(), Finished: time.Now(), Model: "test/model", Usage: usage})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := LoadUsageLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Runs) != 2 {
+		t.Fatalf("loaded %d runs, want 2", len(loaded.Runs))
+	}
+	want := Usage{Requests: 3, PromptTokens: 150, CompletionTokens: 30, TotalTokens: 180, Cost: 0.015}
+	if loaded.Total.Requests != want.Requests || loaded.Total.TotalTokens != want.TotalTokens || math.Abs(loaded.Total.Cost-want.Cost) > 1e-9 {
+		t.Errorf("total = %+v, want %+v", loaded.Total, want)
+	}
+}
@@ -0,0 +1,27 @@
+// Package mailaddr parses sender addresses. It is shared by the mail
+// providers, which fill in each email's sender, and the tracker, which keys
+// its records by bare address.
+package mailaddr
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Parse splits a From value into display name and bare address. Values
+// net/mail rejects, such as unquoted commas in the name, fall back to the
+// text between the last pair of angle brackets, and then to the whole value.
+func Parse(from string) (name, address string) {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Name, addr.Address
+	}
+
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.Index(from[start:], ">"); end > 1 {
+			name = strings.Trim(strings.TrimSpace(from[:start]), `"`)
+			return name, strings.TrimSpace(from[start+1 : start+end])
+		}
+	}
+
+	return "", strings.Trim(strings.TrimSpace(from), "<>")
+}
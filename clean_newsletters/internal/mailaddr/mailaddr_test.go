@@ -0,0 +1,25 @@
+package mailaddr
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		from    string
+		name    string
+		address string
+	}{
+		{"news@example.com", "", "news@example.com"},
+		{"Weekly News <news@example.com>", "Weekly News", "news@example.com"},
+		{`"Smith, Jane" <jane@example.com>`, "Smith, Jane", "jane@example.com"},
+		// net/mail rejects the unquoted comma
+		{"Smith, Jane <jane@example.com>", "Smith, Jane", "jane@example.com"},
+		{"<bare@example.com>", "", "bare@example.com"},
+		{"  not an address ", "", "not an address"},
+	}
+	for _, tt := range tests {
+		name, address := Parse(tt.from)
+		if name != tt.name || address != tt.address {
+			t.Errorf("Parse(%q) = %q, %q, want %q, %q", tt.from, name, address, tt.name, tt.address)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package newsletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/llm"
+)
+
+const (
+	// Tokens reserved for each email's entry in the reply.
+	batchReplyTokens = 80
+	// defaultContextWindow is assumed when the model's window is unknown.
+	defaultContextWindow = 8192
+)
+
+var batchSchema = &llm.Schema{
+	Name: "newsletter_batch_classification",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"results": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id":            map[string]interface{}{"type": "string"},
+						"is_newsletter": map[string]interface{}{"type": "boolean"},
+						"is_subscribed": map[string]interface{}{"type": "boolean"},
+						"category":      map[string]interface{}{"type": "string"},
+						"confidence":    map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1},
+						"reason":        map[string]interface{}{"type": "string"},
+					},
+					"required":             []string{"id", "is_newsletter", "is_subscribed", "category", "confidence", "reason"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"results"},
+		"additionalProperties": false,
+	},
+}
+
+// classifyEmails classifies every email, packing as many as fit into each
+// LLM request. IDs missing from a batch reply are retried one at a time.
+// The returned map holds whatever was classified even when an error that
+// should stop the run (auth, rate limiting, budget) is returned.
+func (p *Processor) classifyEmails(ctx context.Context, emails []*email.Email) (map[string]*Classification, error) {
+	results := make(map[string]*Classification, len(emails))
+	matches := p.matchSubscribed(ctx, emails)
+
+	var pending []*email.Email
+	for _, e := range emails {
+		if classification, ok := matches.decided[e.ID]; ok {
+			results[e.ID] = classification
+			continue
+		}
+		if classification := p.classifyLocal(e); classification != nil {
+			results[e.ID] = classification
+			continue
+		}
+		pending = append(pending, e)
+	}
+
+	candidates := matches.candidates
+	queue, err := p.planBatches(ctx, pending, candidates)
+	if err != nil {
+		return results, err
+	}
+	for len(queue) > 0 {
+		batch := queue[0]
+		queue = queue[1:]
+
+		if len(batch) == 1 {
+			if err := p.classifySingle(ctx, batch[0], candidates, results); err != nil {
+				return results, err
+			}
+			continue
+		}
+
+		batchResults, err := p.classifyBatch(ctx, batch, candidates)
+		if err != nil {
+			if isFatal(err) {
+				return results, err
+			}
+			if errors.Is(err, llm.ErrContextLength) {
+				half := len(batch) / 2
+				queue = append([][]*email.Email{batch[:half], batch[half:]}, queue...)
+				continue
+			}
+			log.Printf("Batch classification of %d emails failed, falling back to single requests: %v", len(batch), err)
+		}
+
+		for _, e := range batch {
+			if classification, ok := batchResults[e.ID]; ok {
+				results[e.ID] = classification
+				continue
+			}
+			if err := p.classifySingle(ctx, e, candidates, results); err != nil {
+				return results, err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (p *Processor) classifySingle(ctx context.Context, email *email.Email, candidates map[string][]string, results map[string]*Classification) error {
+	classification, err := p.classify(ctx, email, candidates[email.ID])
+	if err != nil {
+		if isFatal(err) {
+			return err
+		}
+		log.Printf("Failed to classify email %s: %v", email.ID, err)
+		if p.run != nil {
+			p.run.classifyFailed(email.ID)
+		}
+		return nil
+	}
+
+	results[email.ID] = classification
+	return nil
+}
+
+// planBatches groups emails so each batch prompt fits the model's context
+// window, leaving room for the reply, and never exceeds the configured
+// maximum batch size.
+func (p *Processor) planBatches(ctx context.Context, emails []*email.Email, candidates map[string][]string) ([][]*email.Email, error) {
+	maxSize := p.config.LLMBatchSize
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	// Keep a fifth of the window spare because token counts are estimates.
+	budget := p.contextWindow(ctx) * 4 / 5
+	empty, err := p.batchPrompt(nil, candidates)
+	if err != nil {
+		return nil, err
+	}
+	overhead := estimateTokens(empty)
+
+	var batches [][]*email.Email
+	var current []*email.Email
+	used := overhead
+	for _, e := range emails {
+		single, err := p.batchPrompt([]*email.Email{e}, candidates)
+		if err != nil {
+			return nil, err
+		}
+		cost := estimateTokens(single) - overhead + batchReplyTokens
+		if len(current) > 0 && (len(current) >= maxSize || used+cost > budget) {
+			batches = append(batches, current)
+			current = nil
+			used = overhead
+		}
+		current = append(current, e)
+		used += cost
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+
+	return batches, nil
+}
+
+func (p *Processor) classifyBatch(ctx context.Context, batch []*email.Email, candidates map[string][]string) (map[string]*Classification, error) {
+	prompt, err := p.batchPrompt(batch, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var results map[string]*Classification
+	err = p.completeStructured(ctx, prompt, batchSchema, func(response string) error {
+		parsed, err := parseBatch(response)
+		if err != nil {
+			return err
+		}
+		results = parsed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	template := p.prompts[PromptBatch]
+	for _, classification := range results {
+		classification.Prompt = template.Name
+		classification.PromptHash = template.Hash
+		classification.Model = p.config.OpenRouterModel
+	}
+	return results, nil
+}
+
+func (p *Processor) batchPrompt(batch []*email.Email, candidates map[string][]string) (string, error) {
+	data := batchData{}
+	seen := make(map[string]bool)
+	for _, e := range batch {
+		data.Emails = append(data.Emails, &batchEmail{Email: e, Subscribed: candidates[e.ID]})
+		for _, sender := range candidates[e.ID] {
+			if !seen[sender] {
+				seen[sender] = true
+				data.Subscribed = append(data.Subscribed, sender)
+			}
+		}
+	}
+
+	return p.prompts[PromptBatch].render(data)
+}
+
+// parseBatch accepts {"results": [...]}, a bare array, or an object keyed by
+// email ID. Entries without a usable is_newsletter answer are dropped so the
+// caller retries them individually.
+func parseBatch(response string) (map[string]*Classification, error) {
+	text := strings.TrimSpace(response)
+	start := strings.IndexAny(text, "{[")
+	end := strings.LastIndexAny(text, "}]")
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("no JSON found")
+	}
+	text = text[start : end+1]
+
+	var entries []map[string]interface{}
+	var wrapped struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+	var keyed map[string]map[string]interface{}
+	switch {
+	case json.Unmarshal([]byte(text), &wrapped) == nil && wrapped.Results != nil:
+		entries = wrapped.Results
+	case json.Unmarshal([]byte(text), &entries) == nil:
+	case json.Unmarshal([]byte(text), &keyed) == nil:
+		for id, fields := range keyed {
+			fields["id"] = id
+			entries = append(entries, fields)
+		}
+	default:
+		return nil, fmt.Errorf("unrecognised batch reply")
+	}
+
+	results := make(map[string]*Classification, len(entries))
+	for _, fields := range entries {
+		id, _ := fields["id"].(string)
+		if _, ok := parseBool(fields["is_newsletter"]); !ok || id == "" {
+			continue
+		}
+		classification, err := parseClassification(fields)
+		if err != nil {
+			continue
+		}
+		results[id] = classification
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("batch reply contained no usable results")
+	}
+
+	return results, nil
+}
+
+// contextWindow is LLM_CONTEXT_WINDOW when set, otherwise the window the
+// LLM endpoint lists for the model, looked up once per processor. Endpoints
+// that list none get defaultContextWindow.
+func (p *Processor) contextWindow(ctx context.Context) int {
+	if p.config.LLMContextWindow > 0 {
+		return p.config.LLMContextWindow
+	}
+	if p.window > 0 {
+		return p.window
+	}
+
+	p.window = defaultContextWindow
+	if windower, ok := p.llmClient.(llm.ContextWindower); ok {
+		window, err := windower.ContextWindow(ctx)
+		if err != nil {
+			log.Printf("Unable to look up the model's context window, assuming %d tokens: %v", defaultContextWindow, err)
+		} else {
+			p.window = window
+		}
+	}
+	return p.window
+}
+
+// estimateTokens is a rough, tokenizer-free estimate of about four
+// characters per token.
+func estimateTokens(s string) int {
+	return len(s)/4 + 1
+}
+
+func isFatal(err error) bool {
+	return errors.Is(err, llm.ErrAuth) || errors.Is(err, llm.ErrNoCredits) || errors.Is(err, llm.ErrRateLimited) || errors.Is(err, llm.ErrBudgetExceeded) ||
+		errors.Is(err, llm.ErrNotRecorded)
+}
@@ -0,0 +1,33 @@
+package newsletter
+
+import "testing"
+
+func TestParseBatch(t *testing.T) {
+	response := "```json\n" + `{"results": [
+		{"id": "a", "is_newsletter": true, "is_subscribed": true, "category": "tech", "confidence": 0.9, "reason": "digest"},
+		{"id": 7, "is_newsletter": true, "is_subscribed": false, "category": "", "confidence": 0.9, "reason": ""},
+		{"is_newsletter": false, "is_subscribed": false, "category": "", "confidence": 0.9, "reason": ""},
+		{"id": "c", "is_newsletter": "maybe", "is_subscribed": false, "category": "", "confidence": 0.9, "reason": ""},
+		{"id": "d", "is_newsletter": false, "is_subscribed": false, "category": "", "confidence": 250, "reason": ""}
+	]}` + "\n```"
+
+	results, err := parseBatch(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("parseBatch kept %d results, want only a: %v", len(results), results)
+	}
+	if a := results["a"]; a == nil || !a.IsNewsletter || !a.IsSubscribed || a.Category != "tech" {
+		t.Errorf("result a = %+v", a)
+	}
+	for _, id := range []string{"7", "<nil>", "c", "d"} {
+		if _, ok := results[id]; ok {
+			t.Errorf("kept unusable entry %q", id)
+		}
+	}
+
+	if _, err := parseBatch(`{"results": [{"id": 1, "is_newsletter": true}]}`); err == nil {
+		t.Errorf("parseBatch with only numeric IDs succeeded")
+	}
+}
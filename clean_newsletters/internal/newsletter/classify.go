@@ -0,0 +1,191 @@
+package newsletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"clean_newsletters/internal/llm"
+)
+
+type Classification struct {
+	IsNewsletter bool
+	IsSubscribed bool
+	Category     string
+	Confidence   float64
+	Reason       string
+	// Source is tracker.SourceLLM unless set otherwise.
+	Source string
+	// Model is the LLM, embedding model or local classifier that answered.
+	Model string
+	// Prompt and PromptHash identify the template that produced the answer.
+	Prompt     string
+	PromptHash string
+}
+
+var classificationSchema = &llm.Schema{
+	Name: "newsletter_classification",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"is_newsletter": map[string]interface{}{"type": "boolean"},
+			"is_subscribed": map[string]interface{}{"type": "boolean"},
+			"category":      map[string]interface{}{"type": "string"},
+			"confidence":    map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1},
+			"reason":        map[string]interface{}{"type": "string"},
+		},
+		"required":             []string{"is_newsletter", "is_subscribed", "category", "confidence", "reason"},
+		"additionalProperties": false,
+	},
+}
+
+// completeStructured asks for JSON and hands the reply to parse. If the
+// reply cannot be parsed the model gets one chance to repair it.
+func (p *Processor) completeStructured(ctx context.Context, prompt string, schema *llm.Schema, parse func(response string) error) error {
+	response, err := p.llmClient.CompleteJSON(ctx, prompt, schema)
+	if err != nil {
+		return err
+	}
+
+	parseErr := parse(response)
+	if parseErr == nil {
+		return nil
+	}
+
+	repairPrompt := fmt.Sprintf(`%s
+
+Your previous reply could not be parsed (%v):
+%s
+
+Reply again with only a single JSON object with the keys %s and nothing else.`,
+		prompt,
+		parseErr,
+		truncateString(response, 500),
+		strings.Join(schema.Schema["required"].([]string), ", "))
+
+	response, err = p.llmClient.CompleteJSON(ctx, repairPrompt, schema)
+	if err != nil {
+		return err
+	}
+
+	if err := parse(response); err != nil {
+		return fmt.Errorf("unparseable classifier reply after repair: %v", err)
+	}
+	return nil
+}
+
+// parseStructured extracts the JSON object from a reply, tolerating code
+// fences and surrounding prose. A bare YES/NO answer is accepted as the value
+// of answerKey so older or weaker models still produce usable decisions.
+func parseStructured(response, answerKey string) (map[string]interface{}, error) {
+	text := strings.TrimSpace(response)
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start >= 0 && end > start {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(text[start:end+1]), &fields); err == nil {
+			if _, ok := fields[answerKey]; !ok {
+				return nil, fmt.Errorf("missing %q", answerKey)
+			}
+			if _, ok := parseBool(fields[answerKey]); !ok {
+				return nil, fmt.Errorf("%q is not a boolean", answerKey)
+			}
+			return fields, nil
+		}
+	}
+
+	if answer, ok := bareAnswer(text); ok {
+		return map[string]interface{}{
+			answerKey:    answer,
+			"confidence": 0.5,
+			"reason":     text,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no JSON object found")
+}
+
+// bareAnswer reads a reply that is only YES or NO, as a whole word followed
+// by punctuation or nothing, so "Yes." counts but "Nobody knows" does not.
+func bareAnswer(text string) (bool, bool) {
+	word := strings.ToLower(strings.TrimLeft(text, " \t\n\"'*`"))
+	for _, answer := range []string{"yes", "no"} {
+		if !strings.HasPrefix(word, answer) {
+			continue
+		}
+		rest := word[len(answer):]
+		if r, _ := utf8.DecodeRuneInString(rest); rest == "" || unicode.IsPunct(r) {
+			return answer == "yes", true
+		}
+	}
+	return false, false
+}
+
+func parseClassification(fields map[string]interface{}) (*Classification, error) {
+	isNewsletter, _ := parseBool(fields["is_newsletter"])
+	isSubscribed, _ := parseBool(fields["is_subscribed"])
+	category, _ := fields["category"].(string)
+	reason, _ := fields["reason"].(string)
+	confidence, err := parseConfidence(fields["confidence"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Classification{
+		IsNewsletter: isNewsletter,
+		IsSubscribed: isNewsletter && isSubscribed,
+		Category:     strings.ToLower(strings.TrimSpace(category)),
+		Confidence:   confidence,
+		Reason:       reason,
+	}, nil
+}
+
+func parseBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		switch strings.ToLower(strings.Trim(strings.TrimSpace(v), ".!")) {
+		case "yes", "true", "y":
+			return true, true
+		case "no", "false", "n":
+			return false, true
+		}
+	case float64:
+		return v != 0, true
+	}
+	return false, false
+}
+
+// parseConfidence normalises confidence to 0..1, accepting numbers, numeric
+// strings and percentages. Values in (1, 100] are read as percentages;
+// anything else outside 0..1 is rejected. Missing values count as 0.5.
+func parseConfidence(value interface{}) (float64, error) {
+	var confidence float64
+	switch v := value.(type) {
+	case nil:
+		return 0.5, nil
+	case float64:
+		confidence = v
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("confidence %q is not a number", v)
+		}
+		confidence = parsed
+	default:
+		return 0, fmt.Errorf("confidence %v is not a number", v)
+	}
+
+	switch {
+	case confidence >= 0 && confidence <= 1:
+		return confidence, nil
+	case confidence > 1 && confidence <= 100:
+		return confidence / 100, nil
+	}
+	return 0, fmt.Errorf("confidence %v is out of range", value)
+}
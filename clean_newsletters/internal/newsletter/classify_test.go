@@ -0,0 +1,68 @@
+package newsletter
+
+import "testing"
+
+func TestParseStructuredBareAnswer(t *testing.T) {
+	tests := []struct {
+		response string
+		want     bool
+		wantErr  bool
+	}{
+		{"YES", true, false},
+		{"No.", false, false},
+		{"**Yes**, it is a newsletter", true, false},
+		{"  \"no\"", false, false},
+		{"Yes it is", false, true},
+		{"Nobody knows", false, true},
+		{"yesterday's issue", false, true},
+		{"Maybe", false, true},
+	}
+	for _, tt := range tests {
+		fields, err := parseStructured(tt.response, "is_newsletter")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseStructured(%q) = %v, want an error", tt.response, fields)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStructured(%q): %v", tt.response, err)
+			continue
+		}
+		if fields["is_newsletter"] != tt.want {
+			t.Errorf("parseStructured(%q) = %v, want %t", tt.response, fields["is_newsletter"], tt.want)
+		}
+	}
+}
+
+func TestParseConfidence(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    float64
+		wantErr bool
+	}{
+		{nil, 0.5, false},
+		{0.0, 0, false},
+		{0.85, 0.85, false},
+		{1.0, 1, false},
+		{85.0, 0.85, false},
+		{100.0, 1, false},
+		{"90%", 0.9, false},
+		{"0.7", 0.7, false},
+		{-0.1, 0, true},
+		{100.5, 0, true},
+		{1000.0, 0, true},
+		{"high", 0, true},
+		{true, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseConfidence(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseConfidence(%v) error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseConfidence(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
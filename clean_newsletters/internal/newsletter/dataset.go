@@ -0,0 +1,143 @@
+package newsletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/mailaddr"
+)
+
+// Headers that carry the expected answers in mbox datasets.
+const (
+	HeaderEvalNewsletter = "X-Eval-Newsletter"
+	HeaderEvalSubscribed = "X-Eval-Subscribed"
+)
+
+// LabeledEmail is one dataset entry with its expected classification.
+type LabeledEmail struct {
+	Email      *email.Email
+	Newsletter bool
+	Subscribed bool
+}
+
+// Expected returns the outcome the pipeline should produce.
+func (l *LabeledEmail) Expected() string {
+	switch {
+	case !l.Newsletter:
+		return OutcomeNotNewsletter
+	case l.Subscribed:
+		return OutcomeSubscribed
+	default:
+		return OutcomeUnsubscribed
+	}
+}
+
+type datasetEntry struct {
+	ID         string            `json:"id"`
+	From       string            `json:"from"`
+	Subject    string            `json:"subject"`
+	Body       string            `json:"body"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Newsletter bool              `json:"newsletter"`
+	Subscribed bool              `json:"subscribed"`
+}
+
+// LoadDataset reads a labeled dataset. Files ending in .jsonl hold one
+// datasetEntry per line; anything else is read as an mbox whose messages
+// carry the X-Eval-Newsletter and X-Eval-Subscribed headers.
+func LoadDataset(path string) ([]*LabeledEmail, error) {
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		return loadJSONLDataset(path)
+	}
+	return loadMboxDataset(path)
+}
+
+func loadJSONLDataset(path string) ([]*LabeledEmail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open dataset %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var dataset []*LabeledEmail
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry datasetEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid dataset entry on line %d of %s: %v", line, path, err)
+		}
+		if entry.ID == "" {
+			entry.ID = strconv.Itoa(line)
+		}
+
+		headers := entry.Headers
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		if _, ok := headers["From"]; !ok {
+			headers["From"] = entry.From
+		}
+		if _, ok := headers["Subject"]; !ok {
+			headers["Subject"] = entry.Subject
+		}
+
+		e := &email.Email{
+			ID:      entry.ID,
+			From:    entry.From,
+			Subject: entry.Subject,
+			Body:    entry.Body,
+			Headers: headers,
+		}
+		e.FromName, e.FromAddress = mailaddr.Parse(entry.From)
+
+		dataset = append(dataset, &LabeledEmail{
+			Email:      e,
+			Newsletter: entry.Newsletter,
+			Subscribed: entry.Newsletter && entry.Subscribed,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read dataset %s: %v", path, err)
+	}
+
+	return dataset, nil
+}
+
+func loadMboxDataset(path string) ([]*LabeledEmail, error) {
+	emails, err := email.ReadMbox(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataset []*LabeledEmail
+	for _, e := range emails {
+		value, ok := e.Headers[HeaderEvalNewsletter]
+		if !ok {
+			return nil, fmt.Errorf("message %s (%s) in %s has no %s header", e.ID, e.Subject, path, HeaderEvalNewsletter)
+		}
+		isNewsletter, _ := parseBool(value)
+		isSubscribed, _ := parseBool(e.Headers[HeaderEvalSubscribed])
+
+		// Strip the answers so the classifier never sees them.
+		delete(e.Headers, HeaderEvalNewsletter)
+		delete(e.Headers, HeaderEvalSubscribed)
+
+		dataset = append(dataset, &LabeledEmail{
+			Email:      e,
+			Newsletter: isNewsletter,
+			Subscribed: isNewsletter && isSubscribed,
+		})
+	}
+
+	return dataset, nil
+}
@@ -0,0 +1,177 @@
+package newsletter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/tracker"
+)
+
+// digestHeader marks digest emails, which land in the inbox when sent to
+// the user's own address, so later runs leave them alone.
+const digestHeader = "X-Clean-Newsletters-Digest"
+
+//go:embed digest.html
+var digestSource string
+
+var digestTemplate = template.Must(template.New("digest").Parse(digestSource))
+
+// Digest is the end-of-run email: the newsletters labeled by the run,
+// grouped by sender, and the subscribed senders worth unsubscribing from.
+type Digest struct {
+	Subject    string
+	Profile    string
+	Scanned    int
+	Labeled    int
+	Senders    []*DigestSender
+	Candidates []DigestCandidate
+}
+
+// DigestSender is one sender's newsletters in a Digest.
+type DigestSender struct {
+	Sender string
+	Name   string
+	Issues []DigestIssue
+}
+
+// DigestIssue is one newsletter in a Digest. Link opens the message in
+// Gmail and is empty for other providers; Summary is empty if the LLM
+// could not summarize it.
+type DigestIssue struct {
+	Subject string
+	Summary string
+	Link    string
+}
+
+// DigestCandidate is a subscribed sender the user barely reads.
+// UnsubscribeURL comes from the List-Unsubscribe header of its latest
+// newsletter.
+type DigestCandidate struct {
+	Sender         string
+	Reason         string
+	UnsubscribeURL string
+}
+
+// SendDigest emails the run's digest to DIGEST_TO through the mail
+// provider. Nothing is sent when the run labeled no newsletters and there
+// are no unsubscribe candidates.
+func (p *Processor) SendDigest(ctx context.Context, report *RunReport) error {
+	sender, ok := p.emailClient.(email.Sender)
+	if !ok {
+		return fmt.Errorf("mail provider %s cannot send email", p.config.MailProvider)
+	}
+
+	digest := p.buildDigest(ctx, report)
+	if len(digest.Senders) == 0 && len(digest.Candidates) == 0 {
+		log.Printf("Nothing to put in the digest, not sending it")
+		return nil
+	}
+
+	var html bytes.Buffer
+	if err := digest.Render(&html); err != nil {
+		return err
+	}
+	return sender.SendEmail(ctx, p.config.DigestTo, digest.Subject, html.String(), map[string]string{digestHeader: "1"})
+}
+
+// Render writes the digest as an HTML document.
+func (d *Digest) Render(w io.Writer) error {
+	if err := digestTemplate.Execute(w, d); err != nil {
+		return fmt.Errorf("unable to render digest: %v", err)
+	}
+	return nil
+}
+
+func (p *Processor) buildDigest(ctx context.Context, report *RunReport) *Digest {
+	digest := &Digest{
+		Subject: fmt.Sprintf("Newsletter digest for %s", report.Started.Format("Mon 2 Jan 2006")),
+		Profile: report.Profile,
+		Scanned: report.Scanned,
+		Labeled: report.LabeledTotal(),
+	}
+
+	// Summaries stop at the first error that would fail every request,
+	// such as the budget running out; the digest is sent without them.
+	summarize := true
+	bySender := make(map[string]*DigestSender)
+	for _, e := range report.newsletters {
+		issue := DigestIssue{Subject: e.Subject, Link: p.messageLink(e)}
+		if summarize {
+			summary, err := p.summarize(ctx, e)
+			if err != nil {
+				log.Printf("Failed to summarize email %s: %v", e.ID, err)
+				summarize = !isFatal(err)
+			} else {
+				issue.Summary = summary.Summary
+			}
+		}
+
+		address := strings.ToLower(e.FromAddress)
+		sender, exists := bySender[address]
+		if !exists {
+			sender = &DigestSender{Sender: address, Name: e.FromName}
+			bySender[address] = sender
+			digest.Senders = append(digest.Senders, sender)
+		}
+		sender.Issues = append(sender.Issues, issue)
+	}
+	sort.SliceStable(digest.Senders, func(i, j int) bool {
+		return len(digest.Senders[i].Issues) > len(digest.Senders[j].Issues)
+	})
+
+	candidates, err := p.unsubscribeCandidates(ctx)
+	if err != nil {
+		log.Printf("Failed to list unsubscribe candidates: %v", err)
+	}
+	digest.Candidates = candidates
+
+	return digest
+}
+
+// unsubscribeCandidates returns the subscribed senders whose last counted
+// engagement falls below the re-evaluation thresholds, least engaged first.
+// Engagement is counted by reevaluate and senders report, not here.
+func (p *Processor) unsubscribeCandidates(ctx context.Context) ([]DigestCandidate, error) {
+	var records []tracker.EmailRecord
+	for _, record := range p.tracker.Records() {
+		if record.Status == tracker.StatusSubscribed && unengaged(p.config, record.Engagement) != "" {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Engagement.Score() < records[j].Engagement.Score()
+	})
+
+	bySender, err := p.newsletterDecisions()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]DigestCandidate, 0, len(records))
+	for _, record := range records {
+		candidate := DigestCandidate{Sender: record.Email, Reason: unengaged(p.config, record.Engagement)}
+		// The newest message that still exists has the current link
+		for _, d := range bySender[record.Email] {
+			e, err := p.getHeaders(ctx, d.MessageID)
+			if err != nil {
+				continue
+			}
+			candidate.UnsubscribeURL = e.UnsubscribeURL()
+			break
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
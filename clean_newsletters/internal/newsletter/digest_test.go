@@ -0,0 +1,90 @@
+package newsletter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"clean_newsletters/internal/gmailfake"
+	"clean_newsletters/internal/tracker"
+)
+
+func TestProcessInboxSendsDigest(t *testing.T) {
+	reply := func(summary string) string {
+		return fmt.Sprintf(`{"is_newsletter": true, "is_subscribed": true, "category": "news", "confidence": 0.95, "reason": "test", "summary": %q}`, summary)
+	}
+	completer := &fakeCompleter{replies: map[string]string{
+		"weekly@news.example":   reply("This week's headlines"),
+		"daily@letters.example": reply("Today's letter"),
+	}}
+	p, srv := newTestProcessor(t, completer)
+	srv.SetAddress("me@example.com")
+
+	// An earlier run labels a sender the user then never reads
+	srv.AddMessage(gmailfake.Message{
+		From:    "daily@letters.example",
+		Subject: "Letter 1",
+		Body:    "Dear reader",
+		Headers: map[string]string{"List-Unsubscribe": "<https://letters.example/unsubscribe>"},
+	})
+	if _, err := p.ProcessInbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Sent()) != 0 {
+		t.Fatalf("digest sent without DIGEST_TO")
+	}
+	p.config.ReevaluateMinReadRate = 0.1
+	p.config.ReevaluateMinMessages = 5
+	err := p.tracker.SetEngagement(map[string]*tracker.Engagement{
+		"daily@letters.example": {Messages: 5, Unread: 5, CountedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.config.DigestTo = "me@example.com"
+	srv.AddMessage(gmailfake.Message{From: "Weekly <weekly@news.example>", Subject: "Issue 1", Body: "This week in news"})
+	if _, err := p.ProcessInbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := srv.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want the digest", len(sent))
+	}
+	digest := sent[0]
+	if digest.Headers[digestHeader] != "1" || digest.Headers["To"] != "me@example.com" || !strings.HasPrefix(digest.Subject, "Newsletter digest for ") {
+		t.Errorf("digest headers: subject %q, %v", digest.Subject, digest.Headers)
+	}
+	for _, want := range []string{
+		// The run's new newsletter, with its summary
+		"Weekly", "weekly@news.example", "Issue 1", "This week&#39;s headlines",
+		// The sender the user does not read, with its unsubscribe link
+		`<a href="https://letters.example/unsubscribe">daily@letters.example</a>`, "opened 0 of 5 messages (0%)",
+	} {
+		if !strings.Contains(digest.Body, want) {
+			t.Errorf("digest body lacks %q:\n%s", want, digest.Body)
+		}
+	}
+	// Only this run's newsletters are listed
+	if strings.Contains(digest.Body, "Letter 1") {
+		t.Errorf("digest lists an earlier run's newsletter:\n%s", digest.Body)
+	}
+
+	// The digest arrives in the inbox, where the next run leaves it alone
+	report, err := p.ProcessInbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(srv.LabelNames(digest.ID)); got != "[INBOX SENT UNREAD]" {
+		t.Errorf("digest labels after the next run = %s", got)
+	}
+	if decisions, err := p.tracker.Decisions(digest.ID); err != nil || len(decisions) != 0 {
+		t.Errorf("digest decisions = %+v, %v, want none", decisions, err)
+	}
+	if report.Scanned != 2 || report.Errors != 0 {
+		t.Errorf("next run scanned %d emails with %d errors, want the 2 newsletters only", report.Scanned, report.Errors)
+	}
+}
@@ -0,0 +1,333 @@
+package newsletter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"clean_newsletters/internal/bayes"
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/llm"
+	"clean_newsletters/internal/tracker"
+)
+
+// Outcomes of running one email through the pipeline.
+const (
+	OutcomeNotNewsletter = "not_newsletter"
+	OutcomeSubscribed    = "subscribed"
+	OutcomeUnsubscribed  = "unsubscribed"
+	OutcomeReview        = "review"
+)
+
+var outcomes = []string{OutcomeNotNewsletter, OutcomeSubscribed, OutcomeUnsubscribed, OutcomeReview}
+
+// Metrics counts binary decisions for one question.
+type Metrics struct {
+	TruePositives  int `json:"true_positives"`
+	FalsePositives int `json:"false_positives"`
+	FalseNegatives int `json:"false_negatives"`
+	TrueNegatives  int `json:"true_negatives"`
+}
+
+func (m *Metrics) add(expected, predicted bool) {
+	switch {
+	case expected && predicted:
+		m.TruePositives++
+	case !expected && predicted:
+		m.FalsePositives++
+	case expected && !predicted:
+		m.FalseNegatives++
+	default:
+		m.TrueNegatives++
+	}
+}
+
+func (m Metrics) Precision() float64 {
+	return ratio(m.TruePositives, m.TruePositives+m.FalsePositives)
+}
+
+func (m Metrics) Recall() float64 {
+	return ratio(m.TruePositives, m.TruePositives+m.FalseNegatives)
+}
+
+func (m Metrics) F1() float64 {
+	p, r := m.Precision(), m.Recall()
+	if p+r == 0 {
+		return 0
+	}
+	return 2 * p * r / (p + r)
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// EvalError is one email the pipeline got wrong.
+type EvalError struct {
+	ID        string
+	From      string
+	Subject   string
+	Expected  string
+	Predicted string
+	// Sender is the bare address errors are grouped by.
+	Sender string
+}
+
+// EvalReport summarises an evaluation run.
+type EvalReport struct {
+	Total int
+	// Detection scores "is this a newsletter"; emails sent to review count
+	// as detected.
+	Detection Metrics
+	// Subscription scores "is this a subscribed newsletter" over all emails.
+	Subscription Metrics
+	// Confusion counts expected outcome -> predicted outcome.
+	Confusion map[string]map[string]int
+	Errors    []EvalError
+	Prompts   map[string]string
+	Usage     llm.Usage
+}
+
+// EvalOptions controls which parts of the profile an evaluation uses.
+type EvalOptions struct {
+	// Builtin ignores the profile's prompt overrides and trained local
+	// classifier and matches senders with the lexical embedder instead of
+	// an embeddings endpoint, so the
+	// requests made depend only on the dataset and configuration. Runs
+	// recorded to or replayed from a cassette need this to match each
+	// other.
+	Builtin bool
+}
+
+// Evaluate runs the full classification pipeline over a labeled dataset and
+// scores the result. Tracker history starts empty and builds up as the
+// dataset is processed, exactly as in a real first run; the user's own
+// tracker and mailbox are never touched.
+func Evaluate(ctx context.Context, cfg *config.Config, dataset []*LabeledEmail, llmClient llm.Completer, opts EvalOptions) (*EvalReport, error) {
+	dir, err := os.MkdirTemp("", "clean_newsletters_eval")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create eval directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t, err := tracker.NewFileTracker(filepath.Join(dir, "newsletter_tracker.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	promptDir := filepath.Join(cfg.ProfileDir(), "prompts")
+	if opts.Builtin {
+		promptDir = ""
+	}
+	prompts, err := loadPrompts(promptDir)
+	if err != nil {
+		return nil, err
+	}
+
+	mailbox := newEvalMailbox()
+	p := &Processor{
+		config:      cfg,
+		emailClient: mailbox,
+		llmClient:   llmClient,
+		tracker:     t,
+		prompts:     prompts,
+	}
+	if !opts.Builtin {
+		p.embedder = newEmbedder(cfg)
+
+		// The profile's trained classifier takes part, but nothing the
+		// evaluation decides is recorded as a training example.
+		p.classifier, err = bayes.Load(filepath.Join(cfg.ProfileDir(), modelFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	emails := make([]*email.Email, len(dataset))
+	for i, entry := range dataset {
+		emails[i] = entry.Email
+	}
+	if err := p.processEmails(ctx, emails); err != nil {
+		return nil, err
+	}
+
+	report := &EvalReport{
+		Total:     len(dataset),
+		Confusion: make(map[string]map[string]int),
+		Prompts:   make(map[string]string),
+		Usage:     llmClient.Usage(),
+	}
+	for _, prompt := range prompts {
+		report.Prompts[prompt.Name] = prompt.Hash
+	}
+
+	for _, entry := range dataset {
+		expected := entry.Expected()
+		predicted := mailbox.outcome(entry.Email.ID)
+
+		if report.Confusion[expected] == nil {
+			report.Confusion[expected] = make(map[string]int)
+		}
+		report.Confusion[expected][predicted]++
+
+		report.Detection.add(entry.Newsletter, predicted != OutcomeNotNewsletter)
+		report.Subscription.add(entry.Subscribed, predicted == OutcomeSubscribed)
+
+		if predicted != expected {
+			report.Errors = append(report.Errors, EvalError{
+				ID:        entry.Email.ID,
+				From:      entry.Email.From,
+				Sender:    strings.ToLower(entry.Email.FromAddress),
+				Subject:   entry.Email.Subject,
+				Expected:  expected,
+				Predicted: predicted,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// Write prints the report as plain text.
+func (r *EvalReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "Evaluated %d emails\n", r.Total)
+	names := make([]string, 0, len(r.Prompts))
+	for name := range r.Prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "Prompt %s: %s\n", name, r.Prompts[name])
+	}
+	fmt.Fprintf(w, "LLM requests: %d, tokens: %d, cost: $%.4f\n", r.Usage.Requests, r.Usage.TotalTokens, r.Usage.Cost)
+
+	fmt.Fprintf(w, "\n%-22s %9s %9s %9s\n", "", "precision", "recall", "F1")
+	for _, row := range []struct {
+		name    string
+		metrics Metrics
+	}{
+		{"Newsletter detection", r.Detection},
+		{"Subscription matching", r.Subscription},
+	} {
+		fmt.Fprintf(w, "%-22s %9.3f %9.3f %9.3f\n", row.name, row.metrics.Precision(), row.metrics.Recall(), row.metrics.F1())
+	}
+
+	fmt.Fprintf(w, "\nConfusion matrix (rows expected, columns predicted)\n")
+	fmt.Fprintf(w, "%-15s", "")
+	for _, predicted := range outcomes {
+		fmt.Fprintf(w, " %14s", predicted)
+	}
+	fmt.Fprintln(w)
+	for _, expected := range outcomes[:3] {
+		fmt.Fprintf(w, "%-15s", expected)
+		for _, predicted := range outcomes {
+			fmt.Fprintf(w, " %14d", r.Confusion[expected][predicted])
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(r.Errors) == 0 {
+		return
+	}
+
+	bySender := make(map[string][]EvalError)
+	for _, e := range r.Errors {
+		bySender[e.Sender] = append(bySender[e.Sender], e)
+	}
+	senders := make([]string, 0, len(bySender))
+	for sender := range bySender {
+		senders = append(senders, sender)
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		a, b := bySender[senders[i]], bySender[senders[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return senders[i] < senders[j]
+	})
+
+	fmt.Fprintf(w, "\nErrors by sender\n")
+	for _, sender := range senders {
+		fmt.Fprintf(w, "%s (%d)\n", sender, len(bySender[sender]))
+		for _, e := range bySender[sender] {
+			fmt.Fprintf(w, "  [%s] %s: expected %s, got %s\n", e.ID, truncateString(e.Subject, 60), e.Expected, e.Predicted)
+		}
+	}
+}
+
+// evalMailbox is an in-memory MailProvider that only remembers which labels
+// the processor applied.
+type evalMailbox struct {
+	mu     sync.Mutex
+	labels map[string]map[string]bool
+}
+
+func newEvalMailbox() *evalMailbox {
+	return &evalMailbox{labels: make(map[string]map[string]bool)}
+}
+
+func (m *evalMailbox) outcome(id string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := m.labels[id]
+	switch {
+	case labels[LabelReview]:
+		return OutcomeReview
+	case labels[LabelNewsletter]:
+		return OutcomeSubscribed
+	case labels[LabelUnsubscribe]:
+		return OutcomeUnsubscribed
+	default:
+		return OutcomeNotNewsletter
+	}
+}
+
+func (m *evalMailbox) ListInboxEmails(ctx context.Context) ([]*email.Email, error) {
+	return nil, nil
+}
+
+func (m *evalMailbox) GetEmail(ctx context.Context, messageID string) (*email.Email, error) {
+	return nil, fmt.Errorf("message %s not found", messageID)
+}
+
+func (m *evalMailbox) CreateLabel(ctx context.Context, name string) error {
+	return nil
+}
+
+func (m *evalMailbox) ApplyLabel(ctx context.Context, messageID, labelName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.labels[messageID] == nil {
+		m.labels[messageID] = make(map[string]bool)
+	}
+	m.labels[messageID][labelName] = true
+	return nil
+}
+
+func (m *evalMailbox) RemoveLabel(ctx context.Context, messageID, labelName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.labels[messageID], labelName)
+	return nil
+}
+
+func (m *evalMailbox) MoveEmail(ctx context.Context, messageID, labelName string) error {
+	return m.ApplyLabel(ctx, messageID, labelName)
+}
+
+func (m *evalMailbox) ListLabelEmails(ctx context.Context, labelName string) ([]*email.Email, error) {
+	return nil, nil
+}
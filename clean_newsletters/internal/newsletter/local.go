@@ -0,0 +1,106 @@
+package newsletter
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"clean_newsletters/internal/bayes"
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/tracker"
+)
+
+const (
+	modelFile    = "bayes_model.json"
+	examplesFile = "training_examples.jsonl"
+	// bayesModel is the model name logged for the local classifier.
+	bayesModel = "naive-bayes"
+)
+
+// classifyLocal asks the local Naive Bayes model. It answers only when the
+// model is confident and nothing is left for the LLM to decide: a likely
+// non-newsletter, or a likely newsletter from a sender the tracker already
+// knows. Otherwise it returns nil.
+func (p *Processor) classifyLocal(email *email.Email) *Classification {
+	threshold := p.config.BayesThreshold
+	if p.classifier == nil || threshold == 0 {
+		return nil
+	}
+
+	probability := p.classifier.Predict(bayes.Features(email))
+	switch {
+	case probability <= 1-threshold:
+		return &Classification{
+			IsNewsletter: false,
+			Confidence:   1 - probability,
+			Reason:       fmt.Sprintf("local classifier: newsletter probability %.3f", probability),
+			Source:       tracker.SourceBayes,
+			Model:        bayesModel,
+		}
+	case probability >= threshold:
+		status := p.tracker.GetStatus(email.From, email.ListID())
+		if status != tracker.StatusSubscribed && status != tracker.StatusUnsubscribed {
+			return nil
+		}
+		return &Classification{
+			IsNewsletter: true,
+			IsSubscribed: status == tracker.StatusSubscribed,
+			Confidence:   probability,
+			Reason:       fmt.Sprintf("local classifier: newsletter probability %.3f, known sender", probability),
+			Source:       tracker.SourceBayes,
+			Model:        bayesModel,
+		}
+	}
+	return nil
+}
+
+// recordExample adds a decision to the training examples, skipping the
+// local classifier's own decisions so it never trains on itself.
+func (p *Processor) recordExample(email *email.Email, newsletter bool, source string) {
+	switch source {
+	case tracker.SourceBayes:
+		return
+	case "":
+		source = tracker.SourceLLM
+	}
+	if err := p.examples.Add(email, newsletter, source); err != nil {
+		log.Printf("Failed to record training example: %v", err)
+	}
+}
+
+// TrainResult describes a freshly trained model.
+type TrainResult struct {
+	Path        string
+	Examples    int
+	Newsletters int
+	Other       int
+	Vocabulary  int
+}
+
+// Train retrains the local classifier from every recorded decision and
+// review answer for the profile.
+func Train(cfg *config.Config) (*TrainResult, error) {
+	examples, err := bayes.ReadExamples(filepath.Join(cfg.ProfileDir(), examplesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := bayes.Train(examples)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(cfg.ProfileDir(), modelFile)
+	if err := model.Save(path); err != nil {
+		return nil, fmt.Errorf("failed to save classifier model: %v", err)
+	}
+
+	return &TrainResult{
+		Path:        path,
+		Examples:    model.Examples,
+		Newsletters: model.Newsletter.Documents,
+		Other:       model.Other.Documents,
+		Vocabulary:  model.Vocabulary,
+	}, nil
+}
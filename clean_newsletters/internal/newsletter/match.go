@@ -0,0 +1,151 @@
+package newsletter
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/embedding"
+	"clean_newsletters/internal/tracker"
+)
+
+// senderMatches is the result of matching a run's emails against the
+// subscribed senders.
+type senderMatches struct {
+	// candidates holds the subscribed senders shown to the LLM per email ID.
+	candidates map[string][]string
+	// decided holds emails whose sender matched a subscribed sender closely
+	// enough that the LLM is not asked at all.
+	decided map[string]*Classification
+}
+
+// newEmbedder returns the configured embeddings endpoint, or nil to use the
+// lexical embedder built in memory from each run's subscribed senders.
+func newEmbedder(cfg *config.Config) embedding.Embedder {
+	if cfg.EmbeddingsURL == "" {
+		return nil
+	}
+	return embedding.NewHTTPEmbedder(cfg.EmbeddingsURL, cfg.EmbeddingsAPIKey, cfg.EmbeddingsModel, cfg.LLMTimeout)
+}
+
+// matchSubscribed finds the subscribed senders most similar to each email's
+// sender, so prompts carry only the top-k candidates instead of the whole,
+// ever-growing subscribed list. Each email's vector is kept so it can be
+// stored with the sender's tracker record once the sender is decided.
+func (p *Processor) matchSubscribed(ctx context.Context, emails []*email.Email) *senderMatches {
+	matches := &senderMatches{
+		candidates: make(map[string][]string, len(emails)),
+		decided:    make(map[string]*Classification),
+	}
+	p.vectors = make(map[string]*tracker.Embedding, len(emails))
+
+	subscribed := p.subscribedList()
+	if err := p.embedEmails(ctx, emails, subscribed, matches); err != nil {
+		log.Printf("Sender matching failed, sending the full subscribed list: %v", err)
+		for _, e := range emails {
+			matches.candidates[e.ID] = subscribed
+		}
+	}
+
+	return matches
+}
+
+func (p *Processor) embedEmails(ctx context.Context, emails []*email.Email, subscribed []string, matches *senderMatches) error {
+	// Lexical vectors depend on the subscribed list they were built from,
+	// so they are recomputed each run; only an embeddings model's vectors
+	// are worth keeping with the sender's record.
+	embedder := p.embedder
+	persist := embedder != nil
+	if !persist {
+		embedder = embedding.NewLexicalEmbedder(subscribed)
+	}
+	model := embedder.Name()
+
+	var index embedding.Index
+	var missing []string
+	var missingDocs []embedding.Document
+	for _, sender := range subscribed {
+		if persist {
+			if vector := p.tracker.GetEmbedding(sender, model); vector != nil {
+				index.Add(sender, vector)
+				continue
+			}
+		}
+		missing = append(missing, sender)
+		missingDocs = append(missingDocs, embedding.Document{Sender: sender})
+	}
+
+	if len(missingDocs) > 0 {
+		vectors, err := embedder.Embed(ctx, missingDocs)
+		if err != nil {
+			return fmt.Errorf("failed to embed subscribed senders: %v", err)
+		}
+		stored := make(map[string]*tracker.Embedding, len(missing))
+		for i, sender := range missing {
+			index.Add(sender, vectors[i])
+			stored[sender] = &tracker.Embedding{Model: model, Vector: vectors[i]}
+		}
+		if persist {
+			if err := p.tracker.SetEmbeddings(stored); err != nil {
+				log.Printf("Failed to store sender embeddings: %v", err)
+			}
+		}
+	}
+
+	docs := make([]embedding.Document, len(emails))
+	for i, e := range emails {
+		docs[i] = embedding.Document{Sender: e.From, Subject: e.Subject}
+	}
+	vectors, err := embedder.Embed(ctx, docs)
+	if err != nil {
+		return fmt.Errorf("failed to embed senders: %v", err)
+	}
+
+	for i, e := range emails {
+		if persist {
+			p.vectors[e.ID] = &tracker.Embedding{Model: model, Vector: vectors[i]}
+		}
+
+		top := index.Search(vectors[i], p.config.MatchTopK)
+		if len(top) > 0 && p.config.MatchThreshold > 0 && top[0].Score >= p.config.MatchThreshold && isMailingList(e) {
+			matches.decided[e.ID] = &Classification{
+				IsNewsletter: true,
+				IsSubscribed: true,
+				Confidence:   top[0].Score,
+				Reason:       fmt.Sprintf("mailing list sender matches subscribed sender %s (similarity %.2f)", top[0].Key, top[0].Score),
+				Source:       tracker.SourceEmbedding,
+				Model:        model,
+			}
+			continue
+		}
+
+		candidates := make([]string, len(top))
+		for n, match := range top {
+			candidates[n] = match.Key
+		}
+		matches.candidates[e.ID] = candidates
+	}
+
+	return nil
+}
+
+// isMailingList reports whether the email carries list headers. A sender
+// that merely looks like a subscribed one, such as a person at the same
+// company, is left to the LLM with the match as a candidate.
+func isMailingList(e *email.Email) bool {
+	return e.ListID() != "" || e.Header("List-Unsubscribe") != ""
+}
+
+// storeEmbedding keeps the email's model vector on its sender's record,
+// unless the record already has one from the same model.
+func (p *Processor) storeEmbedding(email *email.Email) {
+	vector, ok := p.vectors[email.ID]
+	if !ok || p.tracker.GetEmbedding(email.From, vector.Model) != nil {
+		return
+	}
+	if err := p.tracker.SetEmbeddings(map[string]*tracker.Embedding{email.From: vector}); err != nil {
+		log.Printf("Failed to store sender embedding: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package newsletter
+
+import (
+	"context"
+	"testing"
+
+	"clean_newsletters/internal/email"
+)
+
+func TestMatchSubscribed(t *testing.T) {
+	p, _ := newTestProcessor(t, &fakeCompleter{})
+	p.config.SubscribedEmails = []string{"Weekly News <weekly@news.example>", "Deals <deals@shop.example>"}
+	p.config.MatchThreshold = 0.5
+
+	list := &email.Email{
+		ID:      "list",
+		From:    "Weekly News <weekly@news.example>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://news.example/unsubscribe>"},
+	}
+	person := &email.Email{ID: "person", From: "Weekly News <weekly@news.example>"}
+
+	matches := p.matchSubscribed(context.Background(), []*email.Email{list, person})
+
+	if c, ok := matches.decided["list"]; !ok || !c.IsNewsletter || !c.IsSubscribed {
+		t.Errorf("list email decision = %+v, want a subscribed newsletter", c)
+	}
+	// Without list headers the match is only a candidate for the LLM
+	if _, ok := matches.decided["person"]; ok {
+		t.Errorf("email without list headers decided by embedding alone")
+	}
+	if candidates := matches.candidates["person"]; len(candidates) == 0 || candidates[0] != list.From {
+		t.Errorf("person candidates = %v, want %s first", candidates, list.From)
+	}
+
+	// Lexical vectors are rebuilt each run rather than stored
+	if len(p.vectors) != 0 {
+		t.Errorf("kept %d lexical vectors for storage", len(p.vectors))
+	}
+}
@@ -2,12 +2,19 @@ package newsletter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"clean_newsletters/internal/bayes"
 	"clean_newsletters/internal/config"
 	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/embedding"
 	"clean_newsletters/internal/llm"
 	"clean_newsletters/internal/tracker"
 )
@@ -15,92 +22,329 @@ import (
 const (
 	LabelNewsletter   = "Newsletter"
 	LabelUnsubscribe = "Unsubscribe"
+	LabelReview      = "Newsletter/Review"
 )
 
 type Processor struct {
 	config      *config.Config
-	emailClient *email.GmailClient
-	llmClient   *llm.OpenRouterClient
+	emailClient email.MailProvider
+	llmClient   llm.Completer
 	tracker     *tracker.Tracker
+	usageLog    *llm.UsageLog
+	prompts     map[string]*promptTemplate
+	embedder    embedding.Embedder
+	classifier  *bayes.Model
+	examples    *bayes.ExampleLog
+	// vectors holds this run's sender embeddings by email ID.
+	vectors map[string]*tracker.Embedding
+	// window is the model's context window once looked up.
+	window int
+	// run collects the report of the ProcessInbox call in progress, if any.
+	run *RunReport
+	// progress receives a line per email as it is processed.
+	progress io.Writer
+	// summaries holds newsletter summaries by email ID.
+	summaries map[string]*Summary
 }
 
-func NewProcessor(cfg *config.Config, emailClient *email.GmailClient) *Processor {
-	llmClient := llm.NewOpenRouterClient(cfg.OpenRouterAPIKey, cfg.OpenRouterModel)
+// NewProcessor creates a processor classifying with llmClient and recording
+// senders in t. The usage log, prompts and local classifier are loaded from
+// the profile directory. The processor owns llmClient and t: Close closes
+// them, and so does NewProcessor when it fails.
+func NewProcessor(cfg *config.Config, emailClient email.MailProvider, llmClient llm.Completer, t *tracker.Tracker) (*Processor, error) {
+	p := &Processor{
+		config:      cfg,
+		emailClient: emailClient,
+		llmClient:   llmClient,
+		tracker:     t,
+		embedder:    newEmbedder(cfg),
+		examples:    bayes.NewExampleLog(filepath.Join(cfg.ProfileDir(), examplesFile)),
+		progress:    os.Stdout,
+	}
+	if err := p.load(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	for _, prompt := range p.prompts {
+		if prompt.Override {
+			log.Printf("Using custom %s prompt (%s)", prompt.Name, prompt.Hash)
+		}
+	}
+	return p, nil
+}
+
+// SetProgress sends the per-email progress lines, printed to standard
+// output by default, to w instead.
+func (p *Processor) SetProgress(w io.Writer) {
+	p.progress = w
+}
 
-	tracker, err := tracker.NewTracker(cfg.AccountProfile)
+func (p *Processor) load() error {
+	var err error
+	p.usageLog, err = llm.LoadUsageLog(filepath.Join(p.config.ProfileDir(), "llm_usage.json"))
 	if err != nil {
-		log.Fatalf("Failed to create tracker: %v", err)
+		return fmt.Errorf("failed to load LLM usage log: %v", err)
 	}
 
-	return &Processor{
-		config:      cfg,
-		emailClient: emailClient,
-		llmClient:   llmClient,
-		tracker:     tracker,
+	p.prompts, err = loadPrompts(filepath.Join(p.config.ProfileDir(), "prompts"))
+	if err != nil {
+		return fmt.Errorf("failed to load prompts: %v", err)
+	}
+
+	p.classifier, err = bayes.Load(filepath.Join(p.config.ProfileDir(), modelFile))
+	if err != nil {
+		return fmt.Errorf("failed to load local classifier: %v", err)
 	}
+	return nil
 }
 
-func (p *Processor) ProcessInbox(ctx context.Context) error {
+// Close saves and closes the tracker and closes the LLM client if it holds
+// any resources, such as a cassette being recorded.
+func (p *Processor) Close() error {
+	err := p.tracker.Close()
+	if closer, ok := p.llmClient.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NewLLMClient creates the LLM client described by the configuration,
+// wrapped in a cassette when LLM_CASSETTE is set.
+func NewLLMClient(cfg *config.Config) (llm.Completer, error) {
+	var live *llm.OpenRouterClient
+	if cfg.LLMCassette == "" || cfg.LLMCassetteMode != llm.CassetteStrict {
+		var prices llm.PriceTable
+		if cfg.LLMPriceTable != "" {
+			var err error
+			prices, err = llm.LoadPriceTable(cfg.LLMPriceTable)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load LLM price table: %v", err)
+			}
+		}
+
+		live = llm.NewOpenRouterClient(cfg.LLMBaseURL, cfg.OpenRouterAPIKey, cfg.OpenRouterModel, llm.Options{
+			Timeout:           cfg.LLMTimeout,
+			MaxRetries:        cfg.LLMMaxRetries,
+			RequestsPerMinute: cfg.LLMRequestsPerMinute,
+			ResponseFormat:    cfg.LLMResponseFormat,
+			Prices:            prices,
+			BudgetUSD:         cfg.LLMBudgetUSD,
+		})
+	}
+
+	if cfg.LLMCassette == "" {
+		return live, nil
+	}
+
+	// A nil *OpenRouterClient must not become a non-nil Completer.
+	var next llm.Completer
+	if live != nil {
+		next = live
+	}
+	return llm.NewCassette(next, cfg.OpenRouterModel, cfg.LLMResponseFormat, cfg.LLMCassette, cfg.LLMCassetteMode)
+}
+
+// ProcessInbox classifies and labels every inbox email and reports what
+// the run did.
+func (p *Processor) ProcessInbox(ctx context.Context) (*RunReport, error) {
+	started := time.Now()
+	p.run = newRunReport(p.config.AccountProfile, started)
+	defer func() { p.run = nil }()
+	report := p.run
+
 	if err := p.emailClient.CreateLabel(ctx, LabelNewsletter); err != nil {
-		return fmt.Errorf("failed to create newsletter label: %v", err)
+		return nil, fmt.Errorf("failed to create newsletter label: %v", err)
 	}
 
 	if err := p.emailClient.CreateLabel(ctx, LabelUnsubscribe); err != nil {
-		return fmt.Errorf("failed to create unsubscribe label: %v", err)
+		return nil, fmt.Errorf("failed to create unsubscribe label: %v", err)
+	}
+
+	if err := p.emailClient.CreateLabel(ctx, LabelReview); err != nil {
+		return nil, fmt.Errorf("failed to create review label: %v", err)
+	}
+
+	if p.config.ReevaluateOnRun {
+		flagged, err := p.Reevaluate(ctx)
+		if err != nil {
+			log.Printf("Failed to re-evaluate senders: %v", err)
+		}
+		for _, r := range flagged {
+			fmt.Fprintf(p.progress, "Re-evaluating %s sender %s: %s\n", r.Status, r.Sender, r.Reason)
+		}
+		report.Reevaluated = len(flagged)
 	}
 
+	listed := time.Now()
 	emails, err := p.emailClient.ListInboxEmails(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list emails: %v", err)
+		return nil, fmt.Errorf("failed to list emails: %v", err)
 	}
+	report.Timings.List = time.Since(listed)
+	emails = withoutDigests(emails)
+	report.Scanned = len(emails)
 
-	fmt.Printf("Found %d emails to process in inbox\n", len(emails))
+	fmt.Fprintf(p.progress, "Found %d emails to process in inbox\n", len(emails))
 
-	for _, email := range emails {
-		if err := p.processEmail(ctx, email); err != nil {
-			log.Printf("Failed to process email %s: %v", email.ID, err)
-			continue
+	if err := p.processEmails(ctx, emails); err != nil {
+		return nil, err
+	}
+
+	// Summaries are made before usage is recorded so they count towards
+	// this run
+	if p.config.ReadingList {
+		if err := p.writeReadingList(ctx, report); err != nil {
+			log.Printf("Failed to write reading list: %v", err)
+		}
+	}
+	if p.config.DigestTo != "" {
+		if err := p.SendDigest(ctx, report); err != nil {
+			log.Printf("Failed to send digest: %v", err)
 		}
 	}
 
-	// Print statistics
-	stats := p.tracker.GetStatistics()
-	fmt.Printf("\n📊 Newsletter Statistics:\n")
-	fmt.Printf("   Total tracked: %d\n", stats["total_emails"])
-	fmt.Printf("   Subscribed: %d\n", stats["subscribed_count"])
-	fmt.Printf("   Unsubscribed: %d\n", stats["unsubscribed_count"])
+	usage := p.llmClient.Usage()
+	err = p.usageLog.Record(llm.RunUsage{
+		Started:  started,
+		Finished: time.Now(),
+		Model:    p.config.OpenRouterModel,
+		Usage:    usage,
+	})
+	if err != nil {
+		log.Printf("Failed to save LLM usage: %v", err)
+	}
 
-	return nil
+	report.Usage = usage
+	report.ProfileUsage = p.usageLog.Total
+	report.ProfileRuns = len(p.usageLog.Runs)
+	report.finish(p.tracker.Statistics())
+
+	return report, nil
+}
+
+// withoutDigests drops digests sent by earlier runs.
+func withoutDigests(emails []*email.Email) []*email.Email {
+	kept := emails[:0]
+	for _, e := range emails {
+		if e.Header(digestHeader) == "" {
+			kept = append(kept, e)
+		}
+	}
+	return kept
 }
 
-func (p *Processor) processEmail(ctx context.Context, email *email.Email) error {
-	isNewsletter, err := p.isNewsletter(ctx, email)
+// processEmails classifies the emails and labels each one. Running out of
+// rate limit or budget stops classification but still labels whatever was
+// classified.
+func (p *Processor) processEmails(ctx context.Context, emails []*email.Email) error {
+	classifyStarted := time.Now()
+	classifications, err := p.classifyEmails(ctx, emails)
+	if p.run != nil {
+		p.run.Timings.Classify = time.Since(classifyStarted)
+		p.run.classified(classifications)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to check if newsletter: %v", err)
+		switch {
+		case errors.Is(err, llm.ErrRateLimited):
+			log.Printf("Still rate limited after retries, stopping early: %v", err)
+		case errors.Is(err, llm.ErrBudgetExceeded), errors.Is(err, llm.ErrNoCredits):
+			log.Printf("Stopping LLM classification: %v", err)
+		default:
+			return fmt.Errorf("failed to classify emails: %w", err)
+		}
+	}
+
+	// Tracker updates are saved together once every email is labeled
+	labelStarted := time.Now()
+	err = p.tracker.Batch(func() error {
+		for _, email := range emails {
+			classification, ok := classifications[email.ID]
+			if !ok {
+				p.recordUnlabeled(ctx, email.ID, false)
+				if p.run != nil {
+					p.run.unclassified(email.ID)
+				}
+				continue
+			}
+			if err := p.processEmail(ctx, email, classification); err != nil {
+				log.Printf("Failed to process email %s: %v", email.ID, err)
+				p.recordUnlabeled(ctx, email.ID, false)
+				if p.run != nil {
+					p.run.Errors++
+				}
+				continue
+			}
+		}
+		return nil
+	})
+	if p.run != nil {
+		p.run.Timings.Label = time.Since(labelStarted)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tracker: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Processor) processEmail(ctx context.Context, email *email.Email, classification *Classification) error {
+	// Check tracker history first; the classifier's subscription answer is
+	// only used for senders we have not decided on before
+	status := p.tracker.GetStatus(email.From, email.ListID())
+	if status == tracker.StatusNotNewsletter {
+		fmt.Fprintf(p.progress, "Email from %s was marked as not a newsletter during review, skipping\n", email.From)
+		p.recordUnlabeled(ctx, email.ID, true)
+		p.logDecision(email, cached("sender marked as not a newsletter during review"), "")
+		return nil
 	}
 
-	if !isNewsletter {
-		fmt.Printf("Email from %s is not a newsletter, skipping\n", email.From)
+	if !classification.IsNewsletter {
+		fmt.Fprintf(p.progress, "Email from %s is not a newsletter (confidence %.2f), skipping\n", email.From, classification.Confidence)
+		p.recordUnlabeled(ctx, email.ID, true)
+		if classification.Confidence >= p.config.ReviewThreshold {
+			p.recordExample(email, false, classification.Source)
+		}
+		p.logDecision(email, classification, "")
 		return nil
 	}
 
-	// Check tracker history first
-	status := p.tracker.GetStatus(email.From)
+	// Questioned senders go back to the user instead of being trusted
+	if status == tracker.StatusSubscribed || status == tracker.StatusUnsubscribed {
+		if reason := p.tracker.ReevaluationReason(email.From); reason != "" {
+			fmt.Fprintf(p.progress, "Email from %s needs review (re-evaluating: %s)\n", email.From, reason)
+			if err := p.emailClient.ApplyLabel(ctx, email.ID, LabelReview); err != nil {
+				return fmt.Errorf("failed to apply label: %v", err)
+			}
+			p.logDecision(email, cached("re-evaluating known "+string(status)+" sender: "+reason), LabelReview)
+			return nil
+		}
+	}
+
 	var isSubscribed bool
-	
+	var decision *tracker.Decision
+
 	switch status {
 	case tracker.StatusSubscribed:
 		isSubscribed = true
-		fmt.Printf("Email from %s is a known subscribed newsletter\n", email.From)
+		fmt.Fprintf(p.progress, "Email from %s is a known subscribed newsletter\n", email.From)
 	case tracker.StatusUnsubscribed:
 		isSubscribed = false
-		fmt.Printf("Email from %s is a known unsubscribed newsletter\n", email.From)
+		fmt.Fprintf(p.progress, "Email from %s is a known unsubscribed newsletter\n", email.From)
 	default:
-		// Unknown - use AI to determine
-		isSubscribed, err = p.isSubscribedNewsletter(ctx, email)
-		if err != nil {
-			return fmt.Errorf("failed to check if subscribed: %v", err)
+		// Unsure decisions go to the review queue without touching the tracker
+		if classification.Confidence < p.config.ReviewThreshold {
+			fmt.Fprintf(p.progress, "Email from %s needs review (confidence %.2f)\n", email.From, classification.Confidence)
+			if err := p.emailClient.ApplyLabel(ctx, email.ID, LabelReview); err != nil {
+				return fmt.Errorf("failed to apply label: %v", err)
+			}
+			p.logDecision(email, classification, LabelReview)
+			return nil
 		}
+		isSubscribed = classification.IsSubscribed
+		decision = newDecision(classification)
 	}
 
 	var label string
@@ -109,80 +353,172 @@ func (p *Processor) processEmail(ctx context.Context, email *email.Email) error
 		label = LabelNewsletter
 		trackerStatus = tracker.StatusSubscribed
 		if status == tracker.StatusUnknown {
-			fmt.Printf("Email from %s is a subscribed newsletter (new)\n", email.From)
+			fmt.Fprintf(p.progress, "Email from %s is a subscribed newsletter (new, confidence %.2f)\n", email.From, decision.Confidence)
 		}
 	} else {
 		label = LabelUnsubscribe
 		trackerStatus = tracker.StatusUnsubscribed
 		if status == tracker.StatusUnknown {
-			fmt.Printf("Email from %s is an unsubscribed newsletter (new)\n", email.From)
+			fmt.Fprintf(p.progress, "Email from %s is an unsubscribed newsletter (new, confidence %.2f)\n", email.From, decision.Confidence)
 		}
 	}
 
 	// Record in tracker
-	if err := p.tracker.RecordEmail(email.From, trackerStatus); err != nil {
+	if err := p.tracker.RecordEmail(email.From, email.ListID(), trackerStatus, decision); err != nil {
 		log.Printf("Failed to record email in tracker: %v", err)
 	}
+	if decision != nil && p.run != nil {
+		p.run.NewSenders = append(p.run.NewSenders, NewSender{
+			Sender:     strings.ToLower(email.FromAddress),
+			Name:       email.FromName,
+			Status:     trackerStatus,
+			Source:     decision.Source,
+			Confidence: decision.Confidence,
+		})
+	}
+	p.storeEmbedding(email)
+	p.recordExample(email, true, classification.Source)
 
 	if err := p.emailClient.ApplyLabel(ctx, email.ID, label); err != nil {
 		return fmt.Errorf("failed to apply label: %v", err)
 	}
+	if err := p.leaveReview(ctx, email); err != nil {
+		return err
+	}
+
+	if decision == nil {
+		p.logDecision(email, cached(fmt.Sprintf("known %s sender", trackerStatus)), label)
+	} else {
+		p.logDecision(email, classification, label)
+	}
 
 	return nil
 }
 
-func (p *Processor) isNewsletter(ctx context.Context, email *email.Email) (bool, error) {
-	prompt := fmt.Sprintf(`Analyze the following email and determine if it's a newsletter. 
-Consider factors like sender patterns, subject line, content structure, and unsubscribe links.
+// recordUnlabeled reports a message that gets no label to providers that
+// keep a decision report, so the report holds the negatives as well:
+// classified messages are not newsletters, the rest could not be classified.
+func (p *Processor) recordUnlabeled(ctx context.Context, messageID string, classified bool) {
+	recorder, ok := p.emailClient.(email.DecisionRecorder)
+	if !ok {
+		return
+	}
+	action := email.ReportActionSkip
+	if classified {
+		action = email.ReportActionNone
+	}
+	if err := recorder.RecordDecision(ctx, messageID, "", action); err != nil {
+		log.Printf("Failed to record decision for email %s: %v", messageID, err)
+	}
+}
 
-From: %s
-Subject: %s
-Body preview: %s
+// leaveReview removes the review label from an email the last run sent to
+// the review queue, now that it has a final label.
+func (p *Processor) leaveReview(ctx context.Context, email *email.Email) error {
+	last, err := p.tracker.LastDecision(email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read decision log: %v", err)
+	}
+	if last == nil || last.Label != LabelReview {
+		return nil
+	}
+	if err := p.emailClient.RemoveLabel(ctx, email.ID, LabelReview); err != nil {
+		return fmt.Errorf("failed to remove review label: %v", err)
+	}
+	return nil
+}
 
-Is this a newsletter? Reply with only YES or NO.`, 
-		email.From, 
-		email.Subject, 
-		truncateString(email.Body, 500))
+// classify answers both questions - is this a newsletter, and is it one of
+// the subscribed senders - in a single LLM call.
+func (p *Processor) classify(ctx context.Context, email *email.Email, subscribedList []string) (*Classification, error) {
+	template := p.prompts[PromptClassify]
+	prompt, err := template.render(classifyData{Email: email, Subscribed: subscribedList})
+	if err != nil {
+		return nil, err
+	}
 
-	response, err := p.llmClient.Complete(ctx, prompt)
+	var classification *Classification
+	err = p.completeStructured(ctx, prompt, classificationSchema, func(response string) error {
+		fields, err := parseStructured(response, "is_newsletter")
+		if err != nil {
+			return err
+		}
+		classification, err = parseClassification(fields)
+		return err
+	})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	response = strings.TrimSpace(strings.ToUpper(response))
-	return response == "YES", nil
+	classification.Prompt = template.Name
+	classification.PromptHash = template.Hash
+	classification.Model = p.config.OpenRouterModel
+	return classification, nil
 }
 
-func (p *Processor) isSubscribedNewsletter(ctx context.Context, email *email.Email) (bool, error) {
-	// Combine environment variable list with tracker's known subscribed emails
-	subscribedList := append(p.config.SubscribedEmails, p.tracker.GetSubscribedEmails()...)
-	
-	if len(subscribedList) == 0 {
-		return false, nil
-	}
-
-	prompt := fmt.Sprintf(`Given the following email and list of subscribed newsletter senders, 
-determine if this email is from one of the subscribed sources. Consider domain names, 
-sender names, and common variations.
+// subscribedList combines the environment variable list with the tracker's
+// known subscribed emails.
+func (p *Processor) subscribedList() []string {
+	return append(append([]string(nil), p.config.SubscribedEmails...), p.tracker.GetSubscribedEmails()...)
+}
 
-Email From: %s
-Email Subject: %s
+// cached describes a decision taken from the sender's tracker status.
+func cached(reason string) *Classification {
+	return &Classification{Source: tracker.SourceCache, Confidence: 1, Reason: reason}
+}
 
-Subscribed Newsletters:
-%s
+// logDecision appends what happened to the message to the tracker's
+// decision log. label is the label applied, if any. Messages seen again
+// with the same label, such as those left in the inbox, are only logged
+// the first time.
+func (p *Processor) logDecision(email *email.Email, classification *Classification, label string) {
+	source := classification.Source
+	if source == "" {
+		source = tracker.SourceLLM
+	}
 
-Is this email from a subscribed newsletter source? Reply with only YES or NO.`,
-		email.From,
-		email.Subject,
-		strings.Join(subscribedList, "\n"))
+	last, err := p.tracker.LastDecision(email.ID)
+	if err != nil {
+		log.Printf("Failed to read decision log for email %s: %v", email.ID, err)
+	}
+	if p.run != nil {
+		p.run.record(email, source, label, last)
+	}
+	if last != nil && last.Label == label {
+		return
+	}
 
-	response, err := p.llmClient.Complete(ctx, prompt)
+	err = p.tracker.LogDecision(&tracker.MessageDecision{
+		MessageID:  email.ID,
+		Sender:     email.From,
+		Subject:    email.Subject,
+		Source:     source,
+		Model:      classification.Model,
+		Confidence: classification.Confidence,
+		Reason:     classification.Reason,
+		Label:      label,
+	})
 	if err != nil {
-		return false, err
+		log.Printf("Failed to log decision for email %s: %v", email.ID, err)
+	}
+}
+
+func newDecision(classification *Classification) *tracker.Decision {
+	source := classification.Source
+	if source == "" {
+		source = tracker.SourceLLM
 	}
 
-	response = strings.TrimSpace(strings.ToUpper(response))
-	return response == "YES", nil
+	return &tracker.Decision{
+		Source:     source,
+		Category:   classification.Category,
+		Confidence: classification.Confidence,
+		Reason:     classification.Reason,
+		Model:      classification.Model,
+		Prompt:     classification.Prompt,
+		PromptHash: classification.PromptHash,
+		DecidedAt:  time.Now(),
+	}
 }
 
 func truncateString(s string, maxLen int) string {
@@ -0,0 +1,255 @@
+package newsletter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"clean_newsletters/internal/auth"
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/gmailfake"
+	"clean_newsletters/internal/llm"
+	"clean_newsletters/internal/tracker"
+)
+
+// fakeCompleter answers classification prompts by sender: the reply for the
+// first sender in replies found in the prompt's From line.
+type fakeCompleter struct {
+	mu       sync.Mutex
+	replies  map[string]string
+	requests int
+}
+
+func (f *fakeCompleter) Complete(ctx context.Context, prompt string) (string, error) {
+	return f.CompleteJSON(ctx, prompt, nil)
+}
+
+func (f *fakeCompleter) CompleteJSON(ctx context.Context, prompt string, schema *llm.Schema) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	for _, line := range strings.Split(prompt, "\n") {
+		if !strings.HasPrefix(line, "From: ") {
+			continue
+		}
+		for sender, reply := range f.replies {
+			if strings.Contains(line, sender) {
+				return reply, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no reply for prompt:\n%s", prompt)
+}
+
+func (f *fakeCompleter) Usage() llm.Usage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return llm.Usage{Requests: f.requests}
+}
+
+func classificationReply(isNewsletter, isSubscribed bool) string {
+	return fmt.Sprintf(`{"is_newsletter": %t, "is_subscribed": %t, "category": "newsletter", "confidence": 0.95, "reason": "test"}`, isNewsletter, isSubscribed)
+}
+
+// newTestProcessor runs a processor against a fresh gmailfake server with
+// its profile in a temporary HOME.
+func newTestProcessor(t *testing.T, completer llm.Completer) (*Processor, *gmailfake.Server) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	srv := gmailfake.NewServer()
+	t.Cleanup(srv.Close)
+	gmailAuth, err := auth.NewGmailAuthWithOptions(context.Background(), srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		AccountProfile:   "test",
+		OpenRouterModel:  "test/model",
+		MailProvider:     config.ProviderGmail,
+		LLMBatchSize:     1,
+		LLMContextWindow: 8192,
+		ReviewThreshold:  0.7,
+		MatchTopK:        5,
+		TrackerStore:     tracker.StoreJSON,
+	}
+	tr, err := tracker.NewTracker(cfg.AccountProfile, cfg.TrackerStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProcessor(cfg, email.NewGmailClient(gmailAuth), completer, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	p.SetProgress(io.Discard)
+	return p, srv
+}
+
+func TestProcessInbox(t *testing.T) {
+	completer := &fakeCompleter{replies: map[string]string{
+		"weekly@news.example": classificationReply(true, true),
+		"deals@shop.example":  classificationReply(true, false),
+		"friend@example.com":  classificationReply(false, false),
+	}}
+	p, srv := newTestProcessor(t, completer)
+
+	weekly := srv.AddMessage(gmailfake.Message{From: "weekly@news.example", Subject: "Issue 1", Body: "This week in news"})
+	deals := srv.AddMessage(gmailfake.Message{From: "deals@shop.example", Subject: "Sale", Body: "50% off"})
+	friend := srv.AddMessage(gmailfake.Message{From: "friend@example.com", Subject: "Lunch?", Body: "Free tomorrow?"})
+	// No reply: the email is skipped without stopping the run
+	unknown := srv.AddMessage(gmailfake.Message{From: "unknown@other.example", Subject: "Hello", Body: "Hi"})
+
+	report, err := p.ProcessInbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := srv.LabelNames(weekly); !contains(got, LabelNewsletter) {
+		t.Errorf("weekly labels = %v, want %s", got, LabelNewsletter)
+	}
+	if got := srv.LabelNames(deals); !contains(got, LabelUnsubscribe) {
+		t.Errorf("deals labels = %v, want %s", got, LabelUnsubscribe)
+	}
+	for _, id := range []string{friend, unknown} {
+		if got := srv.LabelNames(id); contains(got, LabelNewsletter) || contains(got, LabelUnsubscribe) {
+			t.Errorf("labels of %s = %v, want none of ours", id, got)
+		}
+	}
+
+	if status := p.tracker.GetStatus("weekly@news.example", ""); status != tracker.StatusSubscribed {
+		t.Errorf("weekly tracker status = %s", status)
+	}
+	if status := p.tracker.GetStatus("deals@shop.example", ""); status != tracker.StatusUnsubscribed {
+		t.Errorf("deals tracker status = %s", status)
+	}
+	if status := p.tracker.GetStatus("friend@example.com", ""); status != tracker.StatusUnknown {
+		t.Errorf("friend tracker status = %s", status)
+	}
+	if len(report.newsletters) != 1 || report.newsletters[0].ID != weekly {
+		t.Errorf("digest newsletters = %d, want the weekly issue", len(report.newsletters))
+	}
+
+	// The failed classification is an error, not an early stop
+	if report.Scanned != 4 || report.Skipped != 1 || report.Errors != 1 || report.Unclassified != 0 {
+		t.Errorf("report = %+v, want 4 scanned, 1 skipped, 1 error", report)
+	}
+	if report.Labeled[LabelNewsletter] != 1 || report.Labeled[LabelUnsubscribe] != 1 {
+		t.Errorf("labeled = %v", report.Labeled)
+	}
+
+	// The run's usage is added to the profile's usage log
+	if _, err := os.Stat(filepath.Join(p.config.ProfileDir(), "llm_usage.json")); err != nil {
+		t.Errorf("usage log not written: %v", err)
+	}
+
+	// Known senders are still classified by the LLM; their tracker status
+	// decides the label
+	report, err = p.ProcessInbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Classified.LLM != 3 || report.KnownSenders != 2 {
+		t.Errorf("second run classified = %+v, known senders = %d, want 3 LLM and 2 known", report.Classified, report.KnownSenders)
+	}
+	// The weekly issue was already in the first run's digest
+	if len(report.newsletters) != 0 {
+		t.Errorf("second run digest newsletters = %d, want 0", len(report.newsletters))
+	}
+}
+
+// budgetCompleter answers like fakeCompleter until it has made limit
+// requests, then reports the budget as exhausted.
+type budgetCompleter struct {
+	*fakeCompleter
+	limit int
+}
+
+func (b *budgetCompleter) CompleteJSON(ctx context.Context, prompt string, schema *llm.Schema) (string, error) {
+	if b.Usage().Requests >= b.limit {
+		return "", fmt.Errorf("%w: test budget spent", llm.ErrBudgetExceeded)
+	}
+	return b.fakeCompleter.CompleteJSON(ctx, prompt, schema)
+}
+
+func TestProcessInboxStopsOnBudget(t *testing.T) {
+	completer := &budgetCompleter{fakeCompleter: &fakeCompleter{replies: map[string]string{
+		"weekly@news.example": classificationReply(true, true),
+		"deals@shop.example":  classificationReply(true, false),
+		"other@news.example":  classificationReply(true, true),
+	}}, limit: 1}
+	p, srv := newTestProcessor(t, completer)
+	srv.AddMessage(gmailfake.Message{From: "weekly@news.example", Subject: "Issue 1", Body: "This week in news"})
+	srv.AddMessage(gmailfake.Message{From: "deals@shop.example", Subject: "Sale", Body: "50% off"})
+	srv.AddMessage(gmailfake.Message{From: "other@news.example", Subject: "Issue 2", Body: "More news"})
+
+	report, err := p.ProcessInbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The emails left when the budget ran out are unclassified, not errors
+	if report.Classified.LLM != 1 || report.Unclassified != 2 || report.Errors != 0 {
+		t.Errorf("classified = %+v, unclassified = %d, errors = %d, want 1, 2 and 0", report.Classified, report.Unclassified, report.Errors)
+	}
+}
+
+func TestProcessInboxLeavesReview(t *testing.T) {
+	completer := &fakeCompleter{replies: map[string]string{
+		"weekly@news.example": `{"is_newsletter": true, "is_subscribed": true, "category": "news", "confidence": 0.4, "reason": "unsure"}`,
+	}}
+	p, srv := newTestProcessor(t, completer)
+	weekly := srv.AddMessage(gmailfake.Message{From: "weekly@news.example", Subject: "Issue 1", Body: "This week in news"})
+
+	if _, err := p.ProcessInbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.LabelNames(weekly); !contains(got, LabelReview) {
+		t.Fatalf("labels after unsure run = %v, want %s", got, LabelReview)
+	}
+
+	// A confident second run gives the email its final label
+	completer.replies["weekly@news.example"] = classificationReply(true, true)
+	if _, err := p.ProcessInbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := srv.LabelNames(weekly)
+	if !contains(got, LabelNewsletter) || contains(got, LabelReview) {
+		t.Errorf("labels after confident run = %v, want %s without %s", got, LabelNewsletter, LabelReview)
+	}
+}
+
+func TestProcessInboxLogsChangedDecisions(t *testing.T) {
+	completer := &fakeCompleter{replies: map[string]string{
+		"friend@example.com": classificationReply(false, false),
+	}}
+	p, srv := newTestProcessor(t, completer)
+	friend := srv.AddMessage(gmailfake.Message{From: "friend@example.com", Subject: "Lunch?", Body: "Free tomorrow?"})
+
+	// The message stays in the inbox and is seen on every run
+	for run := 0; run < 3; run++ {
+		if _, err := p.ProcessInbox(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if decisions, err := p.tracker.Decisions(friend); err != nil || len(decisions) != 1 {
+		t.Errorf("friend decisions after three runs = %+v, %v, want 1", decisions, err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package newsletter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"clean_newsletters/internal/email"
+)
+
+const (
+	PromptClassify = "classify"
+	PromptBatch    = "batch"
+	PromptSummary  = "summary"
+)
+
+//go:embed prompts/*.tmpl
+var defaultPrompts embed.FS
+
+// promptTemplate is a parsed prompt together with the identity recorded in
+// each decision it produces.
+type promptTemplate struct {
+	Name string
+	// Hash is the first 12 hex digits of the SHA-256 of the template source.
+	Hash     string
+	Override bool
+	tmpl     *template.Template
+}
+
+// classifyData is passed to the classify template.
+type classifyData struct {
+	Email      *email.Email
+	Subscribed []string
+}
+
+// batchData is passed to the batch template. Subscribed is every candidate
+// of every email in the batch.
+type batchData struct {
+	Emails     []*batchEmail
+	Subscribed []string
+}
+
+// batchEmail is an email together with the subscribed senders it most
+// resembles.
+type batchEmail struct {
+	*email.Email
+	Subscribed []string
+}
+
+// summaryData is passed to the summary template.
+type summaryData struct {
+	Email *email.Email
+}
+
+var promptFuncs = template.FuncMap{
+	"truncate": truncateString,
+	"join":     strings.Join,
+}
+
+// loadPrompts reads every prompt from dir/<name>.tmpl, falling back to the
+// embedded default when the file does not exist. An empty dir loads only
+// the defaults.
+func loadPrompts(dir string) (map[string]*promptTemplate, error) {
+	prompts := make(map[string]*promptTemplate)
+	for _, name := range []string{PromptClassify, PromptBatch, PromptSummary} {
+		prompt, err := loadPrompt(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		prompts[name] = prompt
+	}
+	return prompts, nil
+}
+
+func loadPrompt(dir, name string) (*promptTemplate, error) {
+	file := name + ".tmpl"
+	override := dir != ""
+	var source []byte
+	var err error
+	if override {
+		source, err = os.ReadFile(filepath.Join(dir, file))
+		override = !os.IsNotExist(err)
+	}
+	if !override {
+		source, err = defaultPrompts.ReadFile("prompts/" + file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prompt %s: %v", name, err)
+	}
+
+	tmpl, err := template.New(file).Funcs(promptFuncs).Option("missingkey=zero").Parse(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse prompt %s: %v", name, err)
+	}
+
+	sum := sha256.Sum256(source)
+	return &promptTemplate{
+		Name:     name,
+		Hash:     hex.EncodeToString(sum[:])[:12],
+		Override: override,
+		tmpl:     tmpl,
+	}, nil
+}
+
+func (t *promptTemplate) render(data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("unable to render prompt %s: %v", t.Name, err)
+	}
+	return buf.String(), nil
+}
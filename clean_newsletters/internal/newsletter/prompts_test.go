@@ -0,0 +1,108 @@
+package newsletter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clean_newsletters/internal/email"
+)
+
+func writePrompt(t *testing.T, dir, name, source string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+".tmpl"), []byte(source), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPromptsDefaults(t *testing.T) {
+	// A missing prompts directory means every prompt is the default
+	prompts, err := loadPrompts(filepath.Join(t.TempDir(), "prompts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{PromptClassify, PromptBatch} {
+		prompt := prompts[name]
+		if prompt == nil || prompt.Override {
+			t.Fatalf("prompt %s = %+v, want the default", name, prompt)
+		}
+		source, err := defaultPrompts.ReadFile("prompts/" + name + ".tmpl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(source)
+		if want := hex.EncodeToString(sum[:])[:12]; prompt.Hash != want {
+			t.Errorf("prompt %s hash = %s, want %s", name, prompt.Hash, want)
+		}
+	}
+}
+
+func TestLoadPromptsOverride(t *testing.T) {
+	dir := t.TempDir()
+	source := "Is {{.Email.From}} a newsletter? {{truncate .Email.Body 5}}"
+	writePrompt(t, dir, PromptClassify, source)
+
+	prompts, err := loadPrompts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classify := prompts[PromptClassify]
+	if !classify.Override || prompts[PromptBatch].Override {
+		t.Errorf("overrides: classify %t, batch %t, want only classify", classify.Override, prompts[PromptBatch].Override)
+	}
+	sum := sha256.Sum256([]byte(source))
+	if want := hex.EncodeToString(sum[:])[:12]; classify.Hash != want {
+		t.Errorf("override hash = %s, want %s", classify.Hash, want)
+	}
+
+	// Builtin evaluations pass no directory and never read an override,
+	// not even one in the working directory
+	t.Chdir(dir)
+	builtin, err := loadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if builtin[PromptClassify].Override {
+		t.Errorf("loadPrompts(\"\") used the override in %s", dir)
+	}
+
+	got, err := classify.render(classifyData{Email: &email.Email{From: "weekly@news.example", Body: "This week in news"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Is weekly@news.example a newsletter? This ..." {
+		t.Errorf("rendered prompt = %q", got)
+	}
+
+	// Any change to the source changes the hash
+	writePrompt(t, dir, PromptClassify, source+"\n")
+	changed, err := loadPrompts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed[PromptClassify].Hash == classify.Hash {
+		t.Errorf("hash did not change with the template source")
+	}
+}
+
+func TestLoadPromptsErrors(t *testing.T) {
+	dir := t.TempDir()
+	writePrompt(t, dir, PromptBatch, "{{range .Emails}}")
+	if _, err := loadPrompts(dir); err == nil || !strings.Contains(err.Error(), "unable to parse prompt batch") {
+		t.Errorf("unterminated range = %v, want a parse error", err)
+	}
+
+	writePrompt(t, dir, PromptBatch, "{{.Emails}}")
+	writePrompt(t, dir, PromptClassify, "{{.Email.Missing}}")
+	prompts, err := loadPrompts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = prompts[PromptClassify].render(classifyData{Email: &email.Email{}})
+	if err == nil || !strings.Contains(err.Error(), "unable to render prompt classify") {
+		t.Errorf("unknown field = %v, want a render error", err)
+	}
+}
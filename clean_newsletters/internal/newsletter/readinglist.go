@@ -0,0 +1,236 @@
+package newsletter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"clean_newsletters/internal/atomicfile"
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+)
+
+// readingListDir holds one reading list per day in the profile directory.
+const readingListDir = "reading_list"
+
+// ReadingList is one day's summarized newsletters.
+type ReadingList struct {
+	Date  string         `json:"date"`
+	Items []*ReadingItem `json:"items"`
+}
+
+// ReadingItem is one summarized newsletter issue.
+type ReadingItem struct {
+	MessageID string    `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Name      string    `json:"name,omitempty"`
+	Subject   string    `json:"subject"`
+	Link      string    `json:"link,omitempty"`
+	Added     time.Time `json:"added"`
+	Summary
+}
+
+// writeReadingList summarizes the run's newsletters and adds them to
+// today's reading list, written as reading_list/YYYY-MM-DD.json and .md.
+// Runs on the same day add to the same list.
+func (p *Processor) writeReadingList(ctx context.Context, report *RunReport) error {
+	if len(report.newsletters) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(p.config.ProfileDir(), readingListDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("unable to create reading list directory: %v", err)
+	}
+	date := report.Started.Format("2006-01-02")
+	base := filepath.Join(dir, date)
+
+	list, err := loadReadingList(base + ".json")
+	if err != nil {
+		return err
+	}
+	if list.Date == "" {
+		list.Date = date
+	}
+	// Messages labeled Newsletter by an earlier run are on an earlier list
+	listed, err := p.labeledBefore(report.Started)
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		listed[item.MessageID] = true
+	}
+
+	now := time.Now()
+	for _, e := range report.newsletters {
+		if listed[e.ID] {
+			continue
+		}
+		summary, err := p.summarize(ctx, e)
+		if err != nil {
+			if isFatal(err) {
+				log.Printf("Stopping summaries: %v", err)
+				break
+			}
+			log.Printf("Failed to summarize email %s: %v", e.ID, err)
+			continue
+		}
+		list.Items = append(list.Items, &ReadingItem{
+			MessageID: e.ID,
+			Sender:    strings.ToLower(e.FromAddress),
+			Name:      e.FromName,
+			Subject:   e.Subject,
+			Link:      p.messageLink(e),
+			Added:     now,
+			Summary:   *summary,
+		})
+		listed[e.ID] = true
+		report.Summarized++
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(base+".json", data); err != nil {
+		return fmt.Errorf("unable to write reading list: %v", err)
+	}
+
+	var markdown bytes.Buffer
+	list.WriteMarkdown(&markdown)
+	if err := atomicfile.Write(base+".md", markdown.Bytes()); err != nil {
+		return fmt.Errorf("unable to write reading list: %v", err)
+	}
+	return nil
+}
+
+// labeledBefore returns the IDs of messages the decision log shows were
+// labeled Newsletter before the given time.
+func (p *Processor) labeledBefore(started time.Time) (map[string]bool, error) {
+	decisions, err := p.tracker.Decisions("")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read decision log: %v", err)
+	}
+	labeled := make(map[string]bool)
+	for _, d := range decisions {
+		if d.Label == LabelNewsletter && d.Time.Before(started) {
+			labeled[d.MessageID] = true
+		}
+	}
+	return labeled, nil
+}
+
+func loadReadingList(path string) (*ReadingList, error) {
+	list := &ReadingList{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read reading list: %v", err)
+	}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("invalid reading list %s: %v", path, err)
+	}
+	return list, nil
+}
+
+// WriteMarkdown writes the list grouped by sender, busiest senders first.
+func (l *ReadingList) WriteMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "# Reading list for %s\n", l.Date)
+
+	var senders []string
+	bySender := make(map[string][]*ReadingItem)
+	for _, item := range l.Items {
+		if _, exists := bySender[item.Sender]; !exists {
+			senders = append(senders, item.Sender)
+		}
+		bySender[item.Sender] = append(bySender[item.Sender], item)
+	}
+	sort.SliceStable(senders, func(i, j int) bool {
+		return len(bySender[senders[i]]) > len(bySender[senders[j]])
+	})
+
+	for _, sender := range senders {
+		items := bySender[sender]
+		if name := items[0].Name; name != "" {
+			fmt.Fprintf(w, "\n## %s (%s)\n", escapeMarkdown(name), escapeMarkdown(sender))
+		} else {
+			fmt.Fprintf(w, "\n## %s\n", escapeMarkdown(sender))
+		}
+
+		for _, item := range items {
+			subject := escapeMarkdown(item.Subject)
+			if item.Link != "" {
+				fmt.Fprintf(w, "\n### [%s](%s)\n\n", subject, markdownURL(item.Link))
+			} else {
+				fmt.Fprintf(w, "\n### %s\n\n", subject)
+			}
+			fmt.Fprintf(w, "%s\n", escapeMarkdown(item.Summary.Summary))
+			if len(item.Topics) > 0 {
+				topics := make([]string, len(item.Topics))
+				for i, topic := range item.Topics {
+					topics[i] = escapeMarkdown(topic)
+				}
+				fmt.Fprintf(w, "\nTopics: %s\n", strings.Join(topics, ", "))
+			}
+			if len(item.Links) > 0 {
+				fmt.Fprintln(w)
+				for _, link := range item.Links {
+					fmt.Fprintf(w, "- [%s](%s)\n", escapeMarkdown(link.Title), markdownURL(link.URL))
+				}
+			}
+		}
+	}
+}
+
+// markdownEscaper escapes the characters that start emphasis, code, links,
+// HTML, headings or tables anywhere in a line of text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+// listMarker matches the start of a line that would begin a list, a
+// thematic break or a setext heading underline.
+var listMarker = regexp.MustCompile(`^(\d+)([.)])|^[-+=]`)
+
+// escapeMarkdown makes text from senders and the LLM render as itself: it
+// is joined onto one line, so it cannot start a block of its own, and
+// every character with a meaning in Markdown is escaped.
+func escapeMarkdown(text string) string {
+	text = markdownEscaper.Replace(strings.Join(strings.Fields(text), " "))
+	if m := listMarker.FindStringSubmatchIndex(text); m != nil {
+		if m[4] >= 0 {
+			// Escape the punctuation after the number
+			return text[:m[4]] + `\` + text[m[4]:]
+		}
+		return `\` + text
+	}
+	return text
+}
+
+// markdownURL writes a link destination in angle brackets, so spaces and
+// parentheses in the URL cannot end the link.
+func markdownURL(url string) string {
+	url = strings.NewReplacer("<", "%3C", ">", "%3E", "\n", "", "\r", "").Replace(url)
+	return "<" + url + ">"
+}
+
+// messageLink opens the message in the provider's web client, if it has
+// one.
+func (p *Processor) messageLink(e *email.Email) string {
+	if p.config.MailProvider != config.ProviderGmail {
+		return ""
+	}
+	return "https://mail.google.com/mail/u/0/#all/" + e.ID
+}
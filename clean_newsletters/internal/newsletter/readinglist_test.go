@@ -0,0 +1,126 @@
+package newsletter
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/tracker"
+)
+
+func TestReadingListMarkdownEscapes(t *testing.T) {
+	list := &ReadingList{Date: "2026-10-18", Items: []*ReadingItem{{
+		Sender:  "first_last@example.com",
+		Name:    "*Deals* <Weekly>",
+		Subject: "[Weekly] Issue #3",
+		Link:    "https://mail.google.com/mail/u/0/#all/1",
+		Summary: Summary{
+			Summary: "1. Three stories\n# about `code` | tables",
+			Topics:  []string{"C#", "AI_ML"},
+			Links: []Link{
+				{Title: "Arrays [1]", URL: "https://example.com/arrays"},
+				{Title: "- Wiki", URL: "https://en.wikipedia.org/wiki/Go_(game) and more"},
+			},
+		},
+	}}}
+
+	var buf bytes.Buffer
+	list.WriteMarkdown(&buf)
+	got := buf.String()
+	for _, want := range []string{
+		"\n## \\*Deals\\* \\<Weekly\\> (first\\_last@example.com)\n",
+		"\n### [\\[Weekly\\] Issue \\#3](<https://mail.google.com/mail/u/0/#all/1>)\n",
+		"\n1\\. Three stories \\# about \\`code\\` \\| tables\n",
+		"\nTopics: C\\#, AI\\_ML\n",
+		"\n- [Arrays \\[1\\]](<https://example.com/arrays>)\n",
+		"\n- [\\- Wiki](<https://en.wikipedia.org/wiki/Go_(game) and more>)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("markdown missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Plain text", "Plain text"},
+		{"- not a list", `\- not a list`},
+		{"+ not a list", `\+ not a list`},
+		{"=== not a heading", `\=== not a heading`},
+		{"2) not a list", `2\) not a list`},
+		{"2026 in review", "2026 in review"},
+		{"line one\n\n## line two", `line one \#\# line two`},
+		{`C:\path`, `C:\\path`},
+	}
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.text); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if got := markdownURL("https://example.com/<a>\n"); got != "<https://example.com/%3Ca%3E>" {
+		t.Errorf("markdownURL = %q", got)
+	}
+}
+
+func TestWriteReadingListSkipsEarlierRuns(t *testing.T) {
+	p, _ := newTestProcessor(t, &fakeCompleter{})
+	started := time.Now()
+
+	// The first issue was labeled, and listed, by yesterday's run
+	err := p.tracker.LogDecision(&tracker.MessageDecision{
+		MessageID: "old",
+		Sender:    "news@example.com",
+		Time:      started.Add(-24 * time.Hour),
+		Source:    tracker.SourceLLM,
+		Label:     LabelNewsletter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := newRunReport("test", started)
+	for _, id := range []string{"old", "new"} {
+		report.newsletters = append(report.newsletters, &email.Email{ID: id, From: "news@example.com", FromAddress: "news@example.com", Subject: "Issue " + id})
+	}
+	p.summaries = map[string]*Summary{
+		"old": {Summary: "Old issue."},
+		"new": {Summary: "New issue."},
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := p.writeReadingList(context.Background(), report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(p.config.ProfileDir(), readingListDir)
+	list, err := loadReadingList(filepath.Join(dir, started.Format("2006-01-02")+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].MessageID != "new" {
+		t.Errorf("reading list items = %+v, want only the new issue once", list.Items)
+	}
+	if report.Summarized != 1 {
+		t.Errorf("summarized = %d, want 1", report.Summarized)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("reading list directory holds %v, want the .json and .md files", names)
+	}
+}
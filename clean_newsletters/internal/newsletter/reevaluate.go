@@ -0,0 +1,248 @@
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/mailaddr"
+	"clean_newsletters/internal/tracker"
+)
+
+// reevaluateGrace keeps engagement and frequency rules from questioning a
+// decision again right after it was made or confirmed.
+const reevaluateGrace = 30 * 24 * time.Hour
+
+// engagementSample is how many of each sender's most recent messages are
+// re-read to measure engagement.
+const engagementSample = 20
+
+// Reevaluation is a sender flagged by Reevaluate.
+type Reevaluation struct {
+	Sender string
+	Status tracker.EmailStatus
+	Reason string
+}
+
+// Reevaluate refreshes each subscribed sender's engagement and flags the
+// senders whose decision should be questioned again. The next message from
+// a flagged sender goes to the review queue, and answering it there clears
+// the flag.
+func (p *Processor) Reevaluate(ctx context.Context) ([]Reevaluation, error) {
+	if err := p.countEngagement(ctx); err != nil {
+		log.Printf("Engagement unavailable, re-evaluating by age only: %v", err)
+	}
+
+	var rates map[string]float64
+	if p.config.ReevaluateMaxPerWeek > 0 {
+		decisions, err := p.tracker.Decisions("")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read decision log: %v", err)
+		}
+		rates = weeklyRates(decisions)
+	}
+
+	now := time.Now()
+	var flagged []Reevaluation
+	for _, record := range p.tracker.Records() {
+		reason := reevaluationReason(p.config, &record, rates[record.Email], now)
+		if reason == "" {
+			continue
+		}
+		if err := p.tracker.FlagForReevaluation(record.Email, reason); err != nil {
+			return flagged, fmt.Errorf("failed to flag %s: %v", record.Email, err)
+		}
+		flagged = append(flagged, Reevaluation{Sender: record.Email, Status: record.Status, Reason: reason})
+	}
+
+	return flagged, nil
+}
+
+// countEngagement re-reads the most recent messages the tool labeled
+// Newsletter for each subscribed sender and counts what the user has done
+// with them since. Messages that cannot be read are left out of the counts
+// rather than stopping the whole count.
+func (p *Processor) countEngagement(ctx context.Context) error {
+	bySender, err := p.newsletterDecisions()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	engagement := make(map[string]*tracker.Engagement, len(bySender))
+	var failed int
+	var lastErr error
+	for sender, messages := range bySender {
+		if len(messages) > engagementSample {
+			messages = messages[:engagementSample]
+		}
+
+		counts := &tracker.Engagement{CountedAt: now}
+		for _, d := range messages {
+			e, err := p.getHeaders(ctx, d.MessageID)
+			if errors.Is(err, email.ErrNotFound) {
+				counts.Messages++
+				counts.Unread++
+				counts.Deleted++
+				continue
+			}
+			if err != nil {
+				failed++
+				lastErr = err
+				continue
+			}
+			counts.Messages++
+			if e.Unread {
+				counts.Unread++
+			}
+			if e.Starred {
+				counts.Starred++
+			}
+			if e.Replied {
+				counts.Replied++
+			}
+			if e.Deleted {
+				counts.Deleted++
+			}
+		}
+		engagement[sender] = counts
+	}
+	if failed > 0 {
+		log.Printf("Left %d unreadable messages out of engagement counts, last error: %v", failed, lastErr)
+	}
+
+	return p.tracker.SetEngagement(engagement)
+}
+
+// getHeaders fetches a message without its body when the provider can.
+func (p *Processor) getHeaders(ctx context.Context, messageID string) (*email.Email, error) {
+	if reader, ok := p.emailClient.(email.HeaderReader); ok {
+		return reader.GetHeaders(ctx, messageID)
+	}
+	return p.emailClient.GetEmail(ctx, messageID)
+}
+
+// newsletterDecisions returns the decision log entries of messages labeled
+// Newsletter for each subscribed sender, newest first. Only a message's
+// latest entry counts, so messages later moved by review are left out.
+func (p *Processor) newsletterDecisions() (map[string][]*tracker.MessageDecision, error) {
+	subscribed := make(map[string]bool)
+	for _, record := range p.tracker.Records() {
+		if record.Status == tracker.StatusSubscribed {
+			subscribed[record.Email] = true
+		}
+	}
+
+	decisions, err := p.tracker.Decisions("")
+	if err != nil {
+		return nil, err
+	}
+	// Later entries for a message replace earlier ones
+	latest := make(map[string]*tracker.MessageDecision)
+	for _, d := range decisions {
+		latest[d.MessageID] = d
+	}
+	bySender := make(map[string][]*tracker.MessageDecision)
+	for _, d := range latest {
+		_, address := mailaddr.Parse(d.Sender)
+		sender := strings.ToLower(address)
+		if d.Label == LabelNewsletter && subscribed[sender] {
+			bySender[sender] = append(bySender[sender], d)
+		}
+	}
+	for _, messages := range bySender {
+		sort.Slice(messages, func(i, j int) bool { return messages[i].Time.After(messages[j].Time) })
+	}
+
+	return bySender, nil
+}
+
+// weeklyRates returns how many messages a week each sender sends, counting
+// each message in the decision log once, over the time between the first
+// and last of them. Re-processing a message never raises the rate, and
+// senders logged over less than a week are left out.
+func weeklyRates(decisions []*tracker.MessageDecision) map[string]float64 {
+	type span struct {
+		messages    map[string]bool
+		first, last time.Time
+	}
+	spans := make(map[string]*span)
+	for _, d := range decisions {
+		_, address := mailaddr.Parse(d.Sender)
+		sender := strings.ToLower(address)
+		s, ok := spans[sender]
+		if !ok {
+			s = &span{messages: make(map[string]bool), first: d.Time, last: d.Time}
+			spans[sender] = s
+		}
+		if s.messages[d.MessageID] {
+			continue
+		}
+		s.messages[d.MessageID] = true
+		if d.Time.Before(s.first) {
+			s.first = d.Time
+		}
+		if d.Time.After(s.last) {
+			s.last = d.Time
+		}
+	}
+
+	rates := make(map[string]float64, len(spans))
+	for sender, s := range spans {
+		if weeks := s.last.Sub(s.first).Hours() / (24 * 7); weeks >= 1 {
+			rates[sender] = float64(len(s.messages)) / weeks
+		}
+	}
+	return rates
+}
+
+// reevaluationReason applies the policy to one sender and returns why its
+// decision should be questioned, or "" if it should stand. perWeek is the
+// sender's rate from weeklyRates.
+func reevaluationReason(cfg *config.Config, record *tracker.EmailRecord, perWeek float64, now time.Time) string {
+	if record.Status != tracker.StatusSubscribed && record.Status != tracker.StatusUnsubscribed {
+		return ""
+	}
+
+	age := now.Sub(record.DecidedAt())
+	if cfg.ReevaluateAfter > 0 && age >= cfg.ReevaluateAfter {
+		return fmt.Sprintf("decided %d days ago", int(age.Hours()/24))
+	}
+	if record.Status != tracker.StatusSubscribed || age < reevaluateGrace {
+		return ""
+	}
+
+	if reason := unengaged(cfg, record.Engagement); reason != "" {
+		return reason
+	}
+
+	if cfg.ReevaluateMaxPerWeek > 0 && perWeek > cfg.ReevaluateMaxPerWeek {
+		return fmt.Sprintf("sends %.1f messages a week", perWeek)
+	}
+
+	return ""
+}
+
+// unengaged returns why the engagement counts suggest the user no longer
+// reads the sender: enough messages, too few of them opened, and none
+// starred or replied to. It returns "" otherwise.
+func unengaged(cfg *config.Config, e *tracker.Engagement) string {
+	if e == nil || cfg.ReevaluateMinReadRate == 0 || e.Messages < cfg.ReevaluateMinMessages {
+		return ""
+	}
+	if e.Starred > 0 || e.Replied > 0 || e.ReadRate() >= cfg.ReevaluateMinReadRate {
+		return ""
+	}
+
+	reason := fmt.Sprintf("opened %d of %d messages (%.0f%%)", e.Opened(), e.Messages, e.ReadRate()*100)
+	if e.Deleted > 0 {
+		reason += fmt.Sprintf(", deleted %d", e.Deleted)
+	}
+	return reason
+}
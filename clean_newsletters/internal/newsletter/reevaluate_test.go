@@ -0,0 +1,112 @@
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"clean_newsletters/internal/config"
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/gmailfake"
+	"clean_newsletters/internal/tracker"
+)
+
+// failingMailbox fails GetEmail for one message, as a provider does when a
+// lookup times out.
+type failingMailbox struct {
+	email.MailProvider
+	failID string
+}
+
+func (f failingMailbox) GetEmail(ctx context.Context, messageID string) (*email.Email, error) {
+	if messageID == f.failID {
+		return nil, errors.New("connection reset")
+	}
+	return f.MailProvider.GetEmail(ctx, messageID)
+}
+
+func TestCountEngagementSkipsUnreadableMessages(t *testing.T) {
+	completer := &fakeCompleter{replies: map[string]string{
+		"weekly@news.example": classificationReply(true, true),
+	}}
+	p, srv := newTestProcessor(t, completer)
+	first := srv.AddMessage(gmailfake.Message{From: "weekly@news.example", Subject: "Issue 1", Body: "One"})
+	srv.AddMessage(gmailfake.Message{From: "weekly@news.example", Subject: "Issue 2", Body: "Two", Labels: []string{"INBOX"}})
+	if _, err := p.ProcessInbox(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	p.emailClient = failingMailbox{MailProvider: p.emailClient, failID: first}
+	if err := p.countEngagement(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	records := p.tracker.Records()
+	if len(records) != 1 || records[0].Engagement == nil {
+		t.Fatalf("records = %+v", records)
+	}
+	if e := records[0].Engagement; e.Messages != 1 || e.Unread != 0 {
+		t.Errorf("engagement = %+v, want the readable message counted as opened", e)
+	}
+}
+
+func TestWeeklyRates(t *testing.T) {
+	start := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	var decisions []*tracker.MessageDecision
+	// Daily sends three messages over two weeks, each processed on ten runs
+	for run := 0; run < 10; run++ {
+		for i, id := range []string{"d1", "d2", "d3"} {
+			decisions = append(decisions, &tracker.MessageDecision{
+				MessageID: id,
+				Sender:    "Daily <Daily@news.example>",
+				Time:      start.Add(time.Duration(7*i)*day + time.Duration(run)*time.Hour),
+			})
+		}
+	}
+	// New has only been seen for a few days
+	decisions = append(decisions,
+		&tracker.MessageDecision{MessageID: "n1", Sender: "new@example.org", Time: start},
+		&tracker.MessageDecision{MessageID: "n2", Sender: "new@example.org", Time: start.Add(3 * day)},
+	)
+
+	rates := weeklyRates(decisions)
+	if got, want := rates["daily@news.example"], 1.5; math.Abs(got-want) > 1e-9 {
+		t.Errorf("daily rate = %.3f, want %.3f", got, want)
+	}
+	if _, ok := rates["new@example.org"]; ok {
+		t.Errorf("rate for a sender seen for under a week = %.1f", rates["new@example.org"])
+	}
+}
+
+func TestReevaluationReason(t *testing.T) {
+	now := time.Now()
+	cfg := &config.Config{ReevaluateMaxPerWeek: 5, ReevaluateMinReadRate: 0.2, ReevaluateMinMessages: 5}
+	decided := func(age time.Duration) *tracker.Decision {
+		return &tracker.Decision{DecidedAt: now.Add(-age)}
+	}
+	old := 60 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		record  tracker.EmailRecord
+		perWeek float64
+		want    string
+	}{
+		{"frequent", tracker.EmailRecord{Status: tracker.StatusSubscribed, Decision: decided(old)}, 12, "sends 12.0 messages a week"},
+		{"within limit", tracker.EmailRecord{Status: tracker.StatusSubscribed, Decision: decided(old)}, 4, ""},
+		// Re-processing inflates the seen count, which is not a rate
+		{"seen often", tracker.EmailRecord{Status: tracker.StatusSubscribed, Decision: decided(old), SeenCount: 500}, 1, ""},
+		{"recent decision", tracker.EmailRecord{Status: tracker.StatusSubscribed, Decision: decided(24 * time.Hour)}, 12, ""},
+		{"unsubscribed", tracker.EmailRecord{Status: tracker.StatusUnsubscribed, Decision: decided(old)}, 12, ""},
+		{"unread", tracker.EmailRecord{Status: tracker.StatusSubscribed, Decision: decided(old), Engagement: &tracker.Engagement{Messages: 10, Unread: 10}}, 0, "opened 0 of 10 messages (0%)"},
+	}
+	for _, tt := range tests {
+		if got := reevaluationReason(cfg, &tt.record, tt.perWeek, now); got != tt.want {
+			t.Errorf("%s: reason = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package newsletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/llm"
+	"clean_newsletters/internal/tracker"
+)
+
+// Report formats accepted by RunReport.Render.
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// topSendersLimit is how many senders RunReport.TopSenders lists.
+const topSendersLimit = 10
+
+// RunReport describes one ProcessInbox run.
+type RunReport struct {
+	Profile  string    `json:"profile"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Timings  Timings   `json:"timings"`
+
+	// Scanned is every email listed from the inbox.
+	Scanned int `json:"scanned"`
+	// Skipped emails were left unlabeled: not newsletters, or from senders
+	// the user marked as not a newsletter.
+	Skipped int `json:"skipped"`
+	// Unclassified emails were never classified because the run stopped
+	// early on a rate limit or the LLM budget.
+	Unclassified int        `json:"unclassified"`
+	Classified   Classified `json:"classified"`
+	// KnownSenders is how many emails were labeled or skipped by their
+	// sender's tracker status rather than their classification.
+	KnownSenders int `json:"known_senders"`
+	// Labeled counts emails by the label applied.
+	Labeled map[string]int `json:"labeled"`
+	// Errors counts emails that failed to classify or label.
+	Errors int `json:"errors"`
+	// Reevaluated is how many senders were flagged before the run.
+	Reevaluated int `json:"reevaluated"`
+	// Summarized is how many newsletters were added to the reading list.
+	Summarized int `json:"summarized"`
+
+	Usage        llm.Usage `json:"llm_usage"`
+	ProfileUsage llm.Usage `json:"profile_llm_usage"`
+	ProfileRuns  int       `json:"profile_runs"`
+
+	TopSenders []SenderCount      `json:"top_senders"`
+	NewSenders []NewSender        `json:"new_senders"`
+	Tracker    tracker.Statistics `json:"tracker"`
+
+	senders map[string]int
+	// failed holds the IDs of emails whose classification failed, which
+	// are counted as errors rather than unclassified.
+	failed map[string]bool
+	// newsletters are the emails this run labeled Newsletter for the first
+	// time, for the digest and reading list.
+	newsletters []*email.Email
+}
+
+// Timings are the wall-clock durations of each stage of a run.
+type Timings struct {
+	List     time.Duration
+	Classify time.Duration
+	Label    time.Duration
+	Total    time.Duration
+}
+
+// MarshalJSON writes the durations in seconds.
+func (t Timings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]float64{
+		"list_seconds":     t.List.Seconds(),
+		"classify_seconds": t.Classify.Seconds(),
+		"label_seconds":    t.Label.Seconds(),
+		"total_seconds":    t.Total.Seconds(),
+	})
+}
+
+// Classified counts emails by what classified them. Embedding matches and
+// the local classifier are the rule-based tiers; everything else took an
+// LLM request.
+type Classified struct {
+	Embedding int `json:"embedding"`
+	Bayes     int `json:"bayes"`
+	LLM       int `json:"llm"`
+}
+
+// Rule is the number of emails decided without the LLM or the tracker.
+func (c Classified) Rule() int {
+	return c.Embedding + c.Bayes
+}
+
+// SenderCount is a sender and how many of the run's emails it sent.
+type SenderCount struct {
+	Sender string `json:"sender"`
+	Count  int    `json:"count"`
+}
+
+// NewSender is a sender the run decided on for the first time.
+type NewSender struct {
+	Sender     string              `json:"sender"`
+	Name       string              `json:"name,omitempty"`
+	Status     tracker.EmailStatus `json:"status"`
+	Source     string              `json:"source"`
+	Confidence float64             `json:"confidence"`
+}
+
+func newRunReport(profile string, started time.Time) *RunReport {
+	return &RunReport{
+		Profile: profile,
+		Started: started,
+		Labeled: make(map[string]int),
+		senders: make(map[string]int),
+		failed:  make(map[string]bool),
+	}
+}
+
+// classified counts what classified each email, before any of them is
+// labeled.
+func (r *RunReport) classified(results map[string]*Classification) {
+	for _, c := range results {
+		switch c.Source {
+		case tracker.SourceEmbedding:
+			r.Classified.Embedding++
+		case tracker.SourceBayes:
+			r.Classified.Bayes++
+		default:
+			r.Classified.LLM++
+		}
+	}
+}
+
+// classifyFailed counts an email the LLM failed to classify.
+func (r *RunReport) classifyFailed(id string) {
+	r.failed[id] = true
+	r.Errors++
+}
+
+// unclassified counts an email that got no classification, unless that
+// was already counted as a failure.
+func (r *RunReport) unclassified(id string) {
+	if !r.failed[id] {
+		r.Unclassified++
+	}
+}
+
+// record counts one email's outcome as written to the decision log. last
+// is the email's previous log entry, if any; emails an earlier run already
+// labeled Newsletter are left out of the digest.
+func (r *RunReport) record(e *email.Email, source, label string, last *tracker.MessageDecision) {
+	if source == tracker.SourceCache {
+		r.KnownSenders++
+	}
+
+	if label == "" {
+		r.Skipped++
+	} else {
+		r.Labeled[label]++
+	}
+	if label == LabelNewsletter && (last == nil || last.Label != LabelNewsletter) {
+		r.newsletters = append(r.newsletters, e)
+	}
+	r.senders[strings.ToLower(e.FromAddress)]++
+}
+
+// finish fills in the totals once the run is over.
+func (r *RunReport) finish(stats tracker.Statistics) {
+	r.Finished = time.Now()
+	r.Timings.Total = r.Finished.Sub(r.Started)
+	r.Tracker = stats
+
+	// Empty lists stay lists in JSON
+	if r.NewSenders == nil {
+		r.NewSenders = []NewSender{}
+	}
+	r.TopSenders = []SenderCount{}
+	for sender, count := range r.senders {
+		r.TopSenders = append(r.TopSenders, SenderCount{Sender: sender, Count: count})
+	}
+	sort.Slice(r.TopSenders, func(i, j int) bool {
+		if r.TopSenders[i].Count != r.TopSenders[j].Count {
+			return r.TopSenders[i].Count > r.TopSenders[j].Count
+		}
+		return r.TopSenders[i].Sender < r.TopSenders[j].Sender
+	})
+	if len(r.TopSenders) > topSendersLimit {
+		r.TopSenders = r.TopSenders[:topSendersLimit]
+	}
+}
+
+// LabeledTotal is the number of emails that were given any label.
+func (r *RunReport) LabeledTotal() int {
+	total := 0
+	for _, count := range r.Labeled {
+		total += count
+	}
+	return total
+}
+
+// Render writes the report in one of the Format constants.
+func (r *RunReport) Render(w io.Writer, format string) error {
+	switch format {
+	case FormatText, "":
+		r.writeText(w)
+	case FormatMarkdown:
+		r.writeMarkdown(w)
+	case FormatJSON:
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	return nil
+}
+
+func (r *RunReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "\nRun report for profile %s\n", r.Profile)
+	fmt.Fprintf(w, "   Scanned: %d\n", r.Scanned)
+	fmt.Fprintf(w, "   Labeled: %d%s\n", r.LabeledTotal(), r.labelBreakdown())
+	fmt.Fprintf(w, "   Skipped: %d\n", r.Skipped)
+	if r.Unclassified > 0 {
+		fmt.Fprintf(w, "   Not classified: %d\n", r.Unclassified)
+	}
+	fmt.Fprintf(w, "   Errors: %d\n", r.Errors)
+	fmt.Fprintf(w, "   Classified by rule: %d (embedding %d, local classifier %d)\n", r.Classified.Rule(), r.Classified.Embedding, r.Classified.Bayes)
+	fmt.Fprintf(w, "   Classified by LLM: %d\n", r.Classified.LLM)
+	fmt.Fprintf(w, "   Handled by known sender status: %d\n", r.KnownSenders)
+	if r.Reevaluated > 0 {
+		fmt.Fprintf(w, "   Senders flagged for re-evaluation: %d\n", r.Reevaluated)
+	}
+	if r.Summarized > 0 {
+		fmt.Fprintf(w, "   Added to reading list: %d\n", r.Summarized)
+	}
+	fmt.Fprintf(w, "   Time: %s\n", r.Timings)
+	fmt.Fprintf(w, "   LLM requests this run: %d\n", r.Usage.Requests)
+	fmt.Fprintf(w, "   LLM tokens this run: %d (prompt %d, completion %d)\n", r.Usage.TotalTokens, r.Usage.PromptTokens, r.Usage.CompletionTokens)
+	fmt.Fprintf(w, "   LLM cost this run: $%.4f\n", r.Usage.Cost)
+	fmt.Fprintf(w, "   LLM cost for profile %s: $%.4f over %d runs\n", r.Profile, r.ProfileUsage.Cost, r.ProfileRuns)
+	fmt.Fprintf(w, "   Tracked senders: %d (subscribed %d, unsubscribed %d, not newsletters %d)\n",
+		r.Tracker.Total, r.Tracker.Subscribed, r.Tracker.Unsubscribed, r.Tracker.NotNewsletter)
+
+	if len(r.TopSenders) > 0 {
+		fmt.Fprintf(w, "\nTop senders\n")
+		for _, s := range r.TopSenders {
+			fmt.Fprintf(w, "   %4d  %s\n", s.Count, s.Sender)
+		}
+	}
+	if len(r.NewSenders) > 0 {
+		fmt.Fprintf(w, "\nNew senders\n")
+		for _, s := range r.NewSenders {
+			fmt.Fprintf(w, "   %s: %s (%s, confidence %.2f)\n", s.Sender, s.Status, s.Source, s.Confidence)
+		}
+	}
+}
+
+func (r *RunReport) writeMarkdown(w io.Writer) {
+	fmt.Fprintf(w, "# Run report for profile %s\n\n", r.Profile)
+	fmt.Fprintf(w, "%s, %s\n\n", r.Started.Format("2006-01-02 15:04"), r.Timings)
+
+	fmt.Fprintf(w, "| | Emails |\n|---|---:|\n")
+	fmt.Fprintf(w, "| Scanned | %d |\n", r.Scanned)
+	for _, label := range sortedKeys(r.Labeled) {
+		fmt.Fprintf(w, "| Labeled `%s` | %d |\n", label, r.Labeled[label])
+	}
+	fmt.Fprintf(w, "| Skipped | %d |\n", r.Skipped)
+	if r.Unclassified > 0 {
+		fmt.Fprintf(w, "| Not classified | %d |\n", r.Unclassified)
+	}
+	fmt.Fprintf(w, "| Errors | %d |\n", r.Errors)
+	fmt.Fprintf(w, "| Classified by embedding match | %d |\n", r.Classified.Embedding)
+	fmt.Fprintf(w, "| Classified by local classifier | %d |\n", r.Classified.Bayes)
+	fmt.Fprintf(w, "| Classified by LLM | %d |\n", r.Classified.LLM)
+	fmt.Fprintf(w, "| Handled by known sender status | %d |\n", r.KnownSenders)
+	if r.Summarized > 0 {
+		fmt.Fprintf(w, "| Added to reading list | %d |\n", r.Summarized)
+	}
+
+	fmt.Fprintf(w, "\n## LLM usage\n\n")
+	fmt.Fprintf(w, "| | Requests | Tokens | Cost |\n|---|---:|---:|---:|\n")
+	fmt.Fprintf(w, "| This run | %d | %d | $%.4f |\n", r.Usage.Requests, r.Usage.TotalTokens, r.Usage.Cost)
+	fmt.Fprintf(w, "| Profile, %d runs | %d | %d | $%.4f |\n", r.ProfileRuns, r.ProfileUsage.Requests, r.ProfileUsage.TotalTokens, r.ProfileUsage.Cost)
+
+	fmt.Fprintf(w, "\n## Tracker\n\n")
+	fmt.Fprintf(w, "%d senders: %d subscribed, %d unsubscribed, %d not newsletters", r.Tracker.Total, r.Tracker.Subscribed, r.Tracker.Unsubscribed, r.Tracker.NotNewsletter)
+	if r.Reevaluated > 0 {
+		fmt.Fprintf(w, ", %d flagged for re-evaluation", r.Reevaluated)
+	}
+	fmt.Fprintf(w, "\n")
+
+	if len(r.TopSenders) > 0 {
+		fmt.Fprintf(w, "\n## Top senders\n\n| Sender | Emails |\n|---|---:|\n")
+		for _, s := range r.TopSenders {
+			fmt.Fprintf(w, "| %s | %d |\n", s.Sender, s.Count)
+		}
+	}
+	if len(r.NewSenders) > 0 {
+		fmt.Fprintf(w, "\n## New senders\n\n| Sender | Status | Source | Confidence |\n|---|---|---|---:|\n")
+		for _, s := range r.NewSenders {
+			fmt.Fprintf(w, "| %s | %s | %s | %.2f |\n", s.Sender, s.Status, s.Source, s.Confidence)
+		}
+	}
+}
+
+func (r *RunReport) labelBreakdown() string {
+	if len(r.Labeled) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, label := range sortedKeys(r.Labeled) {
+		parts = append(parts, fmt.Sprintf("%s %d", label, r.Labeled[label]))
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+func (t Timings) String() string {
+	return fmt.Sprintf("list %s, classify %s, label %s, total %s",
+		t.List.Round(time.Millisecond), t.Classify.Round(time.Millisecond),
+		t.Label.Round(time.Millisecond), t.Total.Round(time.Millisecond))
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
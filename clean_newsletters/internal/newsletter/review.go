@@ -0,0 +1,116 @@
+package newsletter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/tracker"
+)
+
+// Review walks the messages in the review queue, asks the user to decide
+// each one and records the answer as a pinned tracker decision.
+func (p *Processor) Review(ctx context.Context, in io.Reader, out io.Writer) error {
+	emails, err := p.emailClient.ListLabelEmails(ctx, LabelReview)
+	if err != nil {
+		return fmt.Errorf("failed to list emails for review: %v", err)
+	}
+
+	if len(emails) == 0 {
+		fmt.Fprintf(out, "Nothing to review\n")
+		return nil
+	}
+
+	fmt.Fprintf(out, "%d emails to review\n", len(emails))
+	reader := bufio.NewReader(in)
+
+	for i, email := range emails {
+		fmt.Fprintf(out, "\n[%d/%d] From: %s\n", i+1, len(emails), email.From)
+		fmt.Fprintf(out, "        Subject: %s\n", email.Subject)
+		fmt.Fprintf(out, "        %s\n", strings.Join(strings.Fields(truncateString(email.Body, 200)), " "))
+
+		answer, err := prompt(reader, out)
+		if err != nil {
+			return err
+		}
+
+		switch answer {
+		case "q":
+			return nil
+		case "k":
+			continue
+		}
+
+		if err := p.applyReview(ctx, email, answer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func prompt(reader *bufio.Reader, out io.Writer) (string, error) {
+	for {
+		fmt.Fprintf(out, "[s]ubscribed newsletter, [u]nsubscribe, [n]ot a newsletter, s[k]ip, [q]uit: ")
+		line, err := reader.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		switch answer {
+		case "s", "u", "n", "k", "q":
+			return answer, nil
+		}
+		if err == io.EOF {
+			return "q", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read answer: %v", err)
+		}
+	}
+}
+
+func (p *Processor) applyReview(ctx context.Context, email *email.Email, answer string) error {
+	var status tracker.EmailStatus
+	var label string
+	switch answer {
+	case "s":
+		status = tracker.StatusSubscribed
+		label = LabelNewsletter
+	case "u":
+		status = tracker.StatusUnsubscribed
+		label = LabelUnsubscribe
+	case "n":
+		status = tracker.StatusNotNewsletter
+	}
+
+	decision := &tracker.Decision{
+		Source:     tracker.SourceUser,
+		Confidence: 1,
+		Reason:     "decided during review",
+		DecidedAt:  time.Now(),
+	}
+	if err := p.tracker.PinStatus(email.From, email.ListID(), status, decision); err != nil {
+		return fmt.Errorf("failed to record review decision: %v", err)
+	}
+	p.recordExample(email, status != tracker.StatusNotNewsletter, tracker.SourceUser)
+
+	if label != "" {
+		if err := p.emailClient.ApplyLabel(ctx, email.ID, label); err != nil {
+			return fmt.Errorf("failed to apply label: %v", err)
+		}
+	}
+
+	if err := p.emailClient.RemoveLabel(ctx, email.ID, LabelReview); err != nil {
+		return fmt.Errorf("failed to remove review label: %v", err)
+	}
+
+	p.logDecision(email, &Classification{
+		Source:     decision.Source,
+		Confidence: decision.Confidence,
+		Reason:     decision.Reason,
+	}, label)
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package newsletter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"clean_newsletters/internal/tracker"
+)
+
+// SenderEngagement is one subscribed sender's row in a SendersReport.
+type SenderEngagement struct {
+	Sender     string
+	Name       string
+	Engagement tracker.Engagement
+	Score      float64
+	// Reason is why the sender is an unsubscribe candidate, or empty.
+	Reason string
+}
+
+// SendersReport ranks subscribed newsletters by engagement.
+type SendersReport struct {
+	// Senders is ordered from most to least engaged.
+	Senders []SenderEngagement
+	// Unmeasured are subscribed senders with no labeled messages to count.
+	Unmeasured []string
+}
+
+// Candidates returns the senders worth unsubscribing from, least engaged
+// first.
+func (r *SendersReport) Candidates() []SenderEngagement {
+	var candidates []SenderEngagement
+	for i := len(r.Senders) - 1; i >= 0; i-- {
+		if r.Senders[i].Reason != "" {
+			candidates = append(candidates, r.Senders[i])
+		}
+	}
+	return candidates
+}
+
+// SendersReport refreshes engagement for every subscribed sender and ranks
+// them. Unsubscribe candidates use the same rule as re-evaluation.
+func (p *Processor) SendersReport(ctx context.Context) (*SendersReport, error) {
+	if err := p.countEngagement(ctx); err != nil {
+		return nil, fmt.Errorf("failed to count engagement: %v", err)
+	}
+
+	report := &SendersReport{}
+	for _, record := range p.tracker.Records() {
+		if record.Status != tracker.StatusSubscribed {
+			continue
+		}
+		if record.Engagement == nil || record.Engagement.Messages == 0 {
+			report.Unmeasured = append(report.Unmeasured, record.Email)
+			continue
+		}
+		report.Senders = append(report.Senders, SenderEngagement{
+			Sender:     record.Email,
+			Name:       record.Name,
+			Engagement: *record.Engagement,
+			Score:      record.Engagement.Score(),
+			Reason:     unengaged(p.config, record.Engagement),
+		})
+	}
+
+	sort.SliceStable(report.Senders, func(i, j int) bool {
+		return report.Senders[i].Score > report.Senders[j].Score
+	})
+	return report, nil
+}
+
+// Write prints the report as plain text.
+func (r *SendersReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "%-40s %6s %8s %8s %8s %8s %6s\n", "Sender", "Count", "Opened", "Starred", "Replied", "Deleted", "Score")
+	for _, s := range r.Senders {
+		e := s.Engagement
+		fmt.Fprintf(w, "%-40s %6d %7.0f%% %8d %8d %8d %6.2f\n",
+			s.Sender, e.Messages, e.ReadRate()*100, e.Starred, e.Replied, e.Deleted, s.Score)
+	}
+
+	if len(r.Unmeasured) > 0 {
+		fmt.Fprintf(w, "\n%d subscribed senders have no labeled messages to measure\n", len(r.Unmeasured))
+	}
+
+	candidates := r.Candidates()
+	if len(candidates) == 0 {
+		fmt.Fprintf(w, "\nNo unsubscribe candidates\n")
+		return
+	}
+	fmt.Fprintf(w, "\nUnsubscribe candidates\n")
+	for _, s := range candidates {
+		fmt.Fprintf(w, "  %s: %s\n", s.Sender, s.Reason)
+	}
+}
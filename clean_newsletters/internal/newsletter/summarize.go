@@ -0,0 +1,131 @@
+package newsletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/llm"
+)
+
+// summaryLimit caps the links and topics kept per summary.
+const summaryLimit = 5
+
+var summarySchema = &llm.Schema{
+	Name: "newsletter_summary",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"summary": map[string]interface{}{"type": "string"},
+			"links": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"title": map[string]interface{}{"type": "string"},
+						"url":   map[string]interface{}{"type": "string"},
+					},
+					"required":             []string{"title", "url"},
+					"additionalProperties": false,
+				},
+			},
+			"topics": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+		},
+		"required":             []string{"summary", "links", "topics"},
+		"additionalProperties": false,
+	},
+}
+
+// Summary is the LLM's digest of one newsletter issue.
+type Summary struct {
+	Summary string   `json:"summary"`
+	Links   []Link   `json:"links"`
+	Topics  []string `json:"topics"`
+}
+
+// Link is an article linked from a newsletter.
+type Link struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// summarize asks the LLM to summarize a newsletter issue. Summaries are
+// kept for the life of the processor, so the reading list and the digest
+// share one request per message.
+func (p *Processor) summarize(ctx context.Context, e *email.Email) (*Summary, error) {
+	if summary, ok := p.summaries[e.ID]; ok {
+		return summary, nil
+	}
+
+	prompt, err := p.prompts[PromptSummary].render(summaryData{Email: e})
+	if err != nil {
+		return nil, err
+	}
+
+	var summary *Summary
+	err = p.completeStructured(ctx, prompt, summarySchema, func(response string) error {
+		var parsed Summary
+		if err := decodeObject(response, &parsed); err != nil {
+			return err
+		}
+		parsed.Summary = strings.TrimSpace(parsed.Summary)
+		if parsed.Summary == "" {
+			return fmt.Errorf("missing \"summary\"")
+		}
+		summary = &parsed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Links = linksIn(summary.Links, e.Body)
+	if len(summary.Topics) > summaryLimit {
+		summary.Topics = summary.Topics[:summaryLimit]
+	}
+	if p.summaries == nil {
+		p.summaries = make(map[string]*Summary)
+	}
+	p.summaries[e.ID] = summary
+	return summary, nil
+}
+
+// linksIn keeps the http(s) links that really appear in the body, dropping
+// any the model made up or rewrote.
+func linksIn(links []Link, body string) []Link {
+	var kept []Link
+	for _, link := range links {
+		link.URL = strings.TrimSpace(link.URL)
+		lower := strings.ToLower(link.URL)
+		if !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "http://") {
+			continue
+		}
+		if !strings.Contains(body, link.URL) {
+			continue
+		}
+		if strings.TrimSpace(link.Title) == "" {
+			link.Title = link.URL
+		}
+		kept = append(kept, link)
+		if len(kept) == summaryLimit {
+			break
+		}
+	}
+	return kept
+}
+
+// decodeObject unmarshals the first JSON object in a reply, ignoring any
+// text or code fences the model put around it.
+func decodeObject(response string, v interface{}) error {
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return fmt.Errorf("no JSON object found")
+	}
+	return json.Unmarshal([]byte(response[start:end+1]), v)
+}
@@ -0,0 +1,68 @@
+package tracker
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Embedding is a sender vector and the model that produced it.
+type Embedding struct {
+	Model  string
+	Vector []float32
+}
+
+// embeddingJSON is how an Embedding is stored: the vector as base64 of its
+// little-endian float32s, which is a fraction of the size of a JSON array.
+type embeddingJSON struct {
+	Model  string          `json:"model"`
+	Vector json.RawMessage `json:"vector"`
+}
+
+func (e Embedding) MarshalJSON() ([]byte, error) {
+	data := make([]byte, 4*len(e.Vector))
+	for i, v := range e.Vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	vector, err := json.Marshal(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(embeddingJSON{Model: e.Model, Vector: vector})
+}
+
+// UnmarshalJSON also reads vectors written as a JSON array of numbers.
+func (e *Embedding) UnmarshalJSON(b []byte) error {
+	var raw embeddingJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	e.Model = raw.Model
+	e.Vector = nil
+	if len(raw.Vector) == 0 || string(raw.Vector) == "null" {
+		return nil
+	}
+
+	if raw.Vector[0] == '[' {
+		return json.Unmarshal(raw.Vector, &e.Vector)
+	}
+
+	var encoded string
+	if err := json.Unmarshal(raw.Vector, &encoded); err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid embedding vector: %v", err)
+	}
+	if len(data)%4 != 0 {
+		return fmt.Errorf("invalid embedding vector: %d bytes", len(data))
+	}
+	e.Vector = make([]float32, len(data)/4)
+	for i := range e.Vector {
+		e.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package tracker
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddingJSON(t *testing.T) {
+	in := Embedding{Model: "test/embed", Vector: []float32{0.25, -1, 3.5e-8, 0}}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "[") {
+		t.Errorf("vector stored as an array: %s", data)
+	}
+
+	var out Embedding
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+
+	// Records written before vectors were encoded still load
+	var old Embedding
+	if err := json.Unmarshal([]byte(`{"model": "m", "vector": [0.5, 1]}`), &old); err != nil {
+		t.Fatal(err)
+	}
+	if old.Model != "m" || !reflect.DeepEqual(old.Vector, []float32{0.5, 1}) {
+		t.Errorf("array vector = %+v", old)
+	}
+
+	if err := json.Unmarshal([]byte(`{"model": "m", "vector": "AAA="}`), &old); err == nil {
+		t.Errorf("truncated vector decoded without error")
+	}
+}
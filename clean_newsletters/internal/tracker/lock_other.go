@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly || windows)
+
+package tracker
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// lockFile fails where there is no file locking to serialize saves, rather
+// than let concurrent runs of one profile lose each other's changes.
+func lockFile(path string) (unlock func() error, err error) {
+	return nil, fmt.Errorf("file locking is not supported on %s", runtime.GOOS)
+}
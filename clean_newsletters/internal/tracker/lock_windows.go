@@ -0,0 +1,28 @@
+//go:build windows
+
+package tracker
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on the first byte of path, creating it
+// if needed, and blocks until any other process holding it lets go.
+func lockFile(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	handle := windows.Handle(f.Fd())
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, new(windows.Overlapped)); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		windows.UnlockFileEx(handle, 0, 1, 0, new(windows.Overlapped))
+		return f.Close()
+	}, nil
+}
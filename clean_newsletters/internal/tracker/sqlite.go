@@ -0,0 +1,407 @@
+package tracker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations[i] upgrades the database from version i to i+1, as
+// tracked in PRAGMA user_version. Append new versions; never edit old ones.
+// Sender decisions, embeddings and engagement are stored as JSON since they
+// are only ever read back whole.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS senders (
+		email       TEXT PRIMARY KEY,
+		name        TEXT NOT NULL DEFAULT '',
+		domain      TEXT NOT NULL DEFAULT '',
+		list_id     TEXT NOT NULL DEFAULT '',
+		status      TEXT NOT NULL,
+		first_seen  TEXT NOT NULL DEFAULT '',
+		last_seen   TEXT NOT NULL DEFAULT '',
+		seen_count  INTEGER NOT NULL DEFAULT 0,
+		last_action TEXT NOT NULL DEFAULT '',
+		pinned      INTEGER NOT NULL DEFAULT 0,
+		decision    TEXT,
+		embedding   TEXT
+	);
+	CREATE INDEX IF NOT EXISTS senders_domain ON senders (domain);
+	CREATE INDEX IF NOT EXISTS senders_list_id ON senders (list_id);
+	CREATE INDEX IF NOT EXISTS senders_status ON senders (status);`,
+
+	`CREATE TABLE IF NOT EXISTS meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+
+	`CREATE TABLE IF NOT EXISTS decisions (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id  TEXT NOT NULL,
+		sender      TEXT NOT NULL DEFAULT '',
+		subject     TEXT NOT NULL DEFAULT '',
+		time        TEXT NOT NULL,
+		source      TEXT NOT NULL DEFAULT '',
+		model       TEXT NOT NULL DEFAULT '',
+		confidence  REAL NOT NULL DEFAULT 0,
+		reason      TEXT NOT NULL DEFAULT '',
+		label       TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS decisions_message_id ON decisions (message_id);`,
+
+	`ALTER TABLE senders ADD COLUMN engagement TEXT;
+	ALTER TABLE senders ADD COLUMN reevaluate TEXT NOT NULL DEFAULT '';`,
+}
+
+// SQLiteStore keeps records in an embedded SQLite database, one row per
+// sender, so saves only write the senders that changed. Like JSONStore it
+// merges concurrent runs of one profile instead of overwriting their
+// changes.
+type SQLiteStore struct {
+	db *sql.DB
+	// base holds each record as this process last loaded or saved it, so
+	// Save can tell its own changes from those of other processes.
+	base map[string]*EmailRecord
+}
+
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	// Concurrent runs of a profile wait for each other's transactions
+	// instead of failing with SQLITE_BUSY. Transactions begin IMMEDIATE,
+	// taking the write lock up front, so what Save reads cannot change
+	// before it writes.
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open tracker database %s: %v", path, err)
+	}
+	// SQLite allows a single writer; one connection per process keeps
+	// this process from contending with itself.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to upgrade tracker database %s: %v", path, err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrate applies every migration newer than the database's version, each
+// in its own transaction.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration to version %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// senderColumns are the senders columns in the order scanRecord reads them.
+const senderColumns = `email, name, domain, list_id, status, first_seen, last_seen,
+	seen_count, last_action, pinned, decision, embedding, engagement, reevaluate`
+
+func (s *SQLiteStore) Load() (map[string]*EmailRecord, error) {
+	rows, err := s.db.Query(`SELECT ` + senderColumns + ` FROM senders`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string]*EmailRecord)
+	s.base = make(map[string]*EmailRecord)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records[record.Email] = record
+		s.base[record.Email] = cloneRecord(record)
+	}
+
+	return records, rows.Err()
+}
+
+// scanRecord reads one row of senderColumns.
+func scanRecord(row interface{ Scan(dest ...any) error }) (*EmailRecord, error) {
+	var record EmailRecord
+	var firstSeen, lastSeen, lastAction string
+	var decision, embedding, engagement sql.NullString
+	err := row.Scan(&record.Email, &record.Name, &record.Domain, &record.ListID, &record.Status,
+		&firstSeen, &lastSeen, &record.SeenCount, &lastAction, &record.Pinned, &decision, &embedding,
+		&engagement, &record.Reevaluate)
+	if err != nil {
+		return nil, err
+	}
+
+	record.FirstSeen = parseTime(firstSeen)
+	record.LastSeen = parseTime(lastSeen)
+	record.LastAction = parseTime(lastAction)
+	if decision.Valid {
+		if err := json.Unmarshal([]byte(decision.String), &record.Decision); err != nil {
+			return nil, fmt.Errorf("invalid decision for %s: %v", record.Email, err)
+		}
+	}
+	if embedding.Valid {
+		if err := json.Unmarshal([]byte(embedding.String), &record.Embedding); err != nil {
+			return nil, fmt.Errorf("invalid embedding for %s: %v", record.Email, err)
+		}
+	}
+	if engagement.Valid {
+		if err := json.Unmarshal([]byte(engagement.String), &record.Engagement); err != nil {
+			return nil, fmt.Errorf("invalid engagement for %s: %v", record.Email, err)
+		}
+	}
+	return &record, nil
+}
+
+// Save writes every changed record in a single transaction. A record
+// another process saved since this one loaded it is merged as JSONStore
+// does: the seen count is increased by this process's sightings, and only
+// the columns this process changed are written. The merged values are
+// written back into records.
+func (s *SQLiteStore) Save(records map[string]*EmailRecord, changed []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	saved := make(map[string]*EmailRecord, len(changed))
+	for _, key := range changed {
+		record, exists := records[key]
+		if !exists {
+			if _, err := tx.Exec(`DELETE FROM senders WHERE email = ?`, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		merged, err := s.saveRecord(tx, key, record)
+		if err != nil {
+			return fmt.Errorf("unable to save %s: %v", key, err)
+		}
+		saved[key] = merged
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if s.base == nil {
+		s.base = make(map[string]*EmailRecord)
+	}
+	for _, key := range changed {
+		merged, exists := saved[key]
+		if !exists {
+			delete(s.base, key)
+			continue
+		}
+		*records[key] = *merged
+		s.base[key] = cloneRecord(merged)
+	}
+	return nil
+}
+
+// saveRecord writes record within tx and returns it as stored.
+func (s *SQLiteStore) saveRecord(tx *sql.Tx, key string, record *EmailRecord) (*EmailRecord, error) {
+	current, err := scanRecord(tx.QueryRow(`SELECT `+senderColumns+` FROM senders WHERE email = ?`, key))
+	if err == sql.ErrNoRows {
+		return record, insertRecord(tx, key, record)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	base := s.base[key]
+	if base == nil {
+		base = &EmailRecord{}
+	}
+	merged := mergeChanges(base, record, current)
+
+	// Only what this process changed is written, so another process's
+	// changes to the other columns survive
+	var sets []string
+	var args []any
+	set := func(column string, value any) {
+		sets = append(sets, column+" = ?")
+		args = append(args, value)
+	}
+	if delta := record.SeenCount - base.SeenCount; delta != 0 {
+		sets = append(sets, "seen_count = seen_count + ?")
+		args = append(args, delta)
+	}
+	if !merged.FirstSeen.Equal(current.FirstSeen) {
+		set("first_seen", formatTime(merged.FirstSeen))
+	}
+	if !merged.LastSeen.Equal(current.LastSeen) {
+		set("last_seen", formatTime(merged.LastSeen))
+	}
+	if !merged.LastAction.Equal(current.LastAction) {
+		set("last_action", formatTime(merged.LastAction))
+	}
+	if record.Status != base.Status || record.Pinned != base.Pinned || !reflect.DeepEqual(record.Decision, base.Decision) {
+		decision, err := marshalNullable(record.Decision)
+		if err != nil {
+			return nil, err
+		}
+		set("status", string(record.Status))
+		set("pinned", record.Pinned)
+		set("decision", decision)
+	}
+	if record.Name != base.Name {
+		set("name", record.Name)
+	}
+	if record.Domain != base.Domain {
+		set("domain", record.Domain)
+	}
+	if record.ListID != base.ListID {
+		set("list_id", record.ListID)
+	}
+	if !reflect.DeepEqual(record.Embedding, base.Embedding) {
+		embedding, err := marshalNullable(record.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		set("embedding", embedding)
+	}
+	if !reflect.DeepEqual(record.Engagement, base.Engagement) {
+		engagement, err := marshalNullable(record.Engagement)
+		if err != nil {
+			return nil, err
+		}
+		set("engagement", engagement)
+	}
+	if record.Reevaluate != base.Reevaluate {
+		set("reevaluate", record.Reevaluate)
+	}
+	if len(sets) == 0 {
+		return merged, nil
+	}
+
+	args = append(args, key)
+	_, err = tx.Exec(`UPDATE senders SET `+strings.Join(sets, ", ")+` WHERE email = ?`, args...)
+	return merged, err
+}
+
+// insertRecord writes a record that is not in the database.
+func insertRecord(tx *sql.Tx, key string, record *EmailRecord) error {
+	decision, err := marshalNullable(record.Decision)
+	if err != nil {
+		return err
+	}
+	embedding, err := marshalNullable(record.Embedding)
+	if err != nil {
+		return err
+	}
+	engagement, err := marshalNullable(record.Engagement)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO senders (`+senderColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, record.Name, record.Domain, record.ListID, string(record.Status),
+		formatTime(record.FirstSeen), formatTime(record.LastSeen), record.SeenCount,
+		formatTime(record.LastAction), record.Pinned, decision, embedding, engagement, record.Reevaluate)
+	return err
+}
+
+func (s *SQLiteStore) Meta(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *SQLiteStore) SetMeta(key, value string) error {
+	_, err := s.db.Exec(`INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+func (s *SQLiteStore) AppendDecision(decision *MessageDecision) error {
+	_, err := s.db.Exec(`INSERT INTO decisions (message_id, sender, subject, time, source, model,
+		confidence, reason, label) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		decision.MessageID, decision.Sender, decision.Subject, formatTime(decision.Time), decision.Source,
+		decision.Model, decision.Confidence, decision.Reason, decision.Label)
+	return err
+}
+
+func (s *SQLiteStore) Decisions(messageID string) ([]*MessageDecision, error) {
+	query := `SELECT message_id, sender, subject, time, source, model, confidence, reason, label
+		FROM decisions`
+	var args []interface{}
+	if messageID != "" {
+		query += ` WHERE message_id = ?`
+		args = append(args, messageID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []*MessageDecision
+	for rows.Next() {
+		var decision MessageDecision
+		var decided string
+		err := rows.Scan(&decision.MessageID, &decision.Sender, &decision.Subject, &decided, &decision.Source,
+			&decision.Model, &decision.Confidence, &decision.Reason, &decision.Label)
+		if err != nil {
+			return nil, err
+		}
+		decision.Time = parseTime(decided)
+		decisions = append(decisions, &decision)
+	}
+
+	return decisions, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// marshalNullable stores nil pointers as NULL rather than "null".
+func marshalNullable[T any](value *T) (sql.NullString, error) {
+	if value == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
@@ -0,0 +1,355 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"clean_newsletters/internal/atomicfile"
+)
+
+// lockName is the advisory lock file in the profile directory.
+const lockName = ".lock"
+
+// Store names accepted by NewTracker.
+const (
+	StoreJSON   = "json"
+	StoreSQLite = "sqlite"
+)
+
+// Store persists tracker records. The Tracker keeps every record in memory
+// and only uses the store to load them once and to write back changes.
+type Store interface {
+	// Load returns every stored record keyed by address. A store that does
+	// not exist yet returns an empty map.
+	Load() (map[string]*EmailRecord, error)
+	// Save writes the changed keys. records holds every record, so stores
+	// that cannot update in place can rewrite everything; a changed key
+	// missing from records was deleted.
+	Save(records map[string]*EmailRecord, changed []string) error
+	// AppendDecision adds an entry to the message decision log.
+	AppendDecision(decision *MessageDecision) error
+	// Decisions returns the log entries for messageID in the order they were
+	// appended, or every entry if messageID is empty.
+	Decisions(messageID string) ([]*MessageDecision, error)
+	// Meta returns a value set by SetMeta, or "" if it was never set.
+	Meta(key string) (string, error)
+	// SetMeta records facts about the stored data itself, such as which
+	// one-time upgrades have been applied.
+	SetMeta(key, value string) error
+	Close() error
+}
+
+// DefaultBackups is how many previous versions of the JSON tracker are
+// kept, as newsletter_tracker.json.1 (newest) to .N.
+const DefaultBackups = 5
+
+// JSONStore keeps all records in one indented JSON file. Saves are atomic
+// and serialized across processes by a lock file in the same directory, so
+// concurrent runs of one profile merge their changes instead of overwriting
+// each other. The decision log is a JSONL file next to it.
+type JSONStore struct {
+	path    string
+	backups int
+	logMu   sync.Mutex
+	// base holds each record as this process last loaded or saved it, so
+	// Save can tell its own changes from those of other processes.
+	base map[string]*EmailRecord
+	// rotated is set once this process has backed up the file.
+	rotated bool
+}
+
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path, backups: DefaultBackups}
+}
+
+func (s *JSONStore) Load() (map[string]*EmailRecord, error) {
+	records, _, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.base = make(map[string]*EmailRecord, len(records))
+	for key, record := range records {
+		s.base[key] = cloneRecord(record)
+	}
+	return records, nil
+}
+
+// read returns the records on disk and the raw file they came from.
+func (s *JSONStore) read() (map[string]*EmailRecord, []byte, error) {
+	records := make(map[string]*EmailRecord)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return records, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", s.path, err)
+	}
+	if records == nil {
+		records = make(map[string]*EmailRecord)
+	}
+	return records, data, nil
+}
+
+// Save re-reads the file under the lock and applies only the changed keys
+// to it, so records another process saved since this one loaded are kept.
+// A record both processes changed is merged field by field: seen counts
+// add up, and any other field this process changed replaces the one on
+// disk. The merged values are written back into records.
+func (s *JSONStore) Save(records map[string]*EmailRecord, changed []string) error {
+	unlock, err := lockFile(filepath.Join(filepath.Dir(s.path), lockName))
+	if err != nil {
+		return fmt.Errorf("unable to lock tracker: %v", err)
+	}
+	defer unlock()
+
+	merged, previous, err := s.read()
+	if err != nil {
+		return err
+	}
+	for _, key := range changed {
+		record, exists := records[key]
+		if !exists {
+			delete(merged, key)
+			continue
+		}
+		if current, onDisk := merged[key]; onDisk {
+			*record = *mergeChanges(s.base[key], record, current)
+		}
+		merged[key] = record
+	}
+
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if previous != nil && !s.rotated {
+		if err := s.rotate(previous); err != nil {
+			return fmt.Errorf("unable to back up tracker: %v", err)
+		}
+		s.rotated = true
+	}
+	if err := atomicfile.Write(s.path, data); err != nil {
+		return err
+	}
+
+	if s.base == nil {
+		s.base = make(map[string]*EmailRecord)
+	}
+	for _, key := range changed {
+		if record, exists := records[key]; exists {
+			s.base[key] = cloneRecord(record)
+		} else {
+			delete(s.base, key)
+		}
+	}
+	return nil
+}
+
+// mergeChanges applies the changes from base to mine onto current, the
+// record another process saved. base is nil for a record this process
+// created.
+func mergeChanges(base, mine, current *EmailRecord) *EmailRecord {
+	if base == nil {
+		base = &EmailRecord{}
+	}
+	merged := *current
+
+	merged.SeenCount += mine.SeenCount - base.SeenCount
+	if merged.FirstSeen.IsZero() || (!mine.FirstSeen.IsZero() && mine.FirstSeen.Before(merged.FirstSeen)) {
+		merged.FirstSeen = mine.FirstSeen
+	}
+	if mine.LastSeen.After(merged.LastSeen) {
+		merged.LastSeen = mine.LastSeen
+	}
+	if mine.LastAction.After(merged.LastAction) {
+		merged.LastAction = mine.LastAction
+	}
+
+	if mine.Status != base.Status || mine.Pinned != base.Pinned || !reflect.DeepEqual(mine.Decision, base.Decision) {
+		merged.Status = mine.Status
+		merged.Pinned = mine.Pinned
+		merged.Decision = mine.Decision
+	}
+	if mine.Name != base.Name {
+		merged.Name = mine.Name
+	}
+	if mine.Domain != base.Domain {
+		merged.Domain = mine.Domain
+	}
+	if mine.ListID != base.ListID {
+		merged.ListID = mine.ListID
+	}
+	if !reflect.DeepEqual(mine.Embedding, base.Embedding) {
+		merged.Embedding = mine.Embedding
+	}
+	if !reflect.DeepEqual(mine.Engagement, base.Engagement) {
+		merged.Engagement = mine.Engagement
+	}
+	if mine.Reevaluate != base.Reevaluate {
+		merged.Reevaluate = mine.Reevaluate
+	}
+	return &merged
+}
+
+// cloneRecord returns a deep copy of record, which the tracker goes on
+// changing in place.
+func cloneRecord(record *EmailRecord) *EmailRecord {
+	clone := *record
+	if record.Decision != nil {
+		decision := *record.Decision
+		clone.Decision = &decision
+	}
+	if record.Embedding != nil {
+		clone.Embedding = &Embedding{Model: record.Embedding.Model, Vector: append([]float32(nil), record.Embedding.Vector...)}
+	}
+	if record.Engagement != nil {
+		engagement := *record.Engagement
+		clone.Engagement = &engagement
+	}
+	return &clone
+}
+
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+// logPath is newsletter_tracker_decisions.jsonl for newsletter_tracker.json.
+func (s *JSONStore) logPath() string {
+	return strings.TrimSuffix(s.path, filepath.Ext(s.path)) + "_decisions.jsonl"
+}
+
+// AppendDecision writes the entry as one line with O_APPEND, so entries
+// from concurrent runs never interleave.
+func (s *JSONStore) AppendDecision(decision *MessageDecision) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	f, err := os.OpenFile(s.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open decision log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("unable to write decision log: %v", err)
+	}
+	return nil
+}
+
+func (s *JSONStore) Decisions(messageID string) ([]*MessageDecision, error) {
+	f, err := os.Open(s.logPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open decision log: %v", err)
+	}
+	defer f.Close()
+
+	var decisions []*MessageDecision
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var decision MessageDecision
+		if err := json.Unmarshal(scanner.Bytes(), &decision); err != nil {
+			return nil, fmt.Errorf("invalid decision on line %d of %s: %v", line, s.logPath(), err)
+		}
+		if messageID == "" || decision.MessageID == messageID {
+			decisions = append(decisions, &decision)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read decision log: %v", err)
+	}
+
+	return decisions, nil
+}
+
+// metaPath is newsletter_tracker_meta.json for newsletter_tracker.json.
+func (s *JSONStore) metaPath() string {
+	return strings.TrimSuffix(s.path, filepath.Ext(s.path)) + "_meta.json"
+}
+
+func (s *JSONStore) readMeta() (map[string]string, error) {
+	meta := make(map[string]string)
+	data, err := os.ReadFile(s.metaPath())
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.metaPath(), err)
+	}
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	return meta, nil
+}
+
+func (s *JSONStore) Meta(key string) (string, error) {
+	meta, err := s.readMeta()
+	if err != nil {
+		return "", err
+	}
+	return meta[key], nil
+}
+
+func (s *JSONStore) SetMeta(key, value string) error {
+	unlock, err := lockFile(filepath.Join(filepath.Dir(s.path), lockName))
+	if err != nil {
+		return fmt.Errorf("unable to lock tracker: %v", err)
+	}
+	defer unlock()
+
+	meta, err := s.readMeta()
+	if err != nil {
+		return err
+	}
+	meta[key] = value
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(s.metaPath(), data)
+}
+
+// rotate shifts path.1 .. path.N-1 up by one and writes the current
+// contents to path.1. Save calls it once per process, so the backups are
+// the file as it was before each of the last N runs.
+func (s *JSONStore) rotate(current []byte) error {
+	if s.backups <= 0 {
+		return nil
+	}
+	for i := s.backups - 1; i >= 1; i-- {
+		err := os.Rename(backupPath(s.path, i), backupPath(s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return atomicfile.Write(backupPath(s.path, 1), current)
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,379 @@
+package tracker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecords() map[string]*EmailRecord {
+	seen := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	return map[string]*EmailRecord{
+		"news@example.com": {
+			Email:     "news@example.com",
+			Name:      "Weekly News",
+			Domain:    "example.com",
+			ListID:    "weekly.example.com",
+			Status:    StatusSubscribed,
+			FirstSeen: seen,
+			LastSeen:  seen.Add(48 * time.Hour),
+			SeenCount: 3,
+			Decision:  &Decision{Source: SourceLLM, Confidence: 0.9, Reason: "digest", DecidedAt: seen},
+			Embedding: &Embedding{Model: "test/embed", Vector: []float32{0.5, -0.5}},
+			Engagement: &Engagement{
+				Messages:  4,
+				Unread:    1,
+				CountedAt: seen,
+			},
+		},
+		"deals@shop.example": {
+			Email:      "deals@shop.example",
+			Domain:     "shop.example",
+			Status:     StatusUnsubscribed,
+			SeenCount:  1,
+			Pinned:     true,
+			Reevaluate: "low engagement",
+		},
+	}
+}
+
+func testStores(t *testing.T) map[string]func() Store {
+	dir := t.TempDir()
+	return map[string]func() Store{
+		StoreJSON: func() Store { return NewJSONStore(filepath.Join(dir, jsonFile)) },
+		StoreSQLite: func() Store {
+			store, err := OpenSQLiteStore(filepath.Join(dir, sqliteFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	for name, open := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := open()
+			records := testRecords()
+			if err := store.Save(records, []string{"deals@shop.example", "news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetMeta("version", "1"); err != nil {
+				t.Fatal(err)
+			}
+			decision := &MessageDecision{MessageID: "m1", Sender: "news@example.com", Time: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Source: SourceLLM, Confidence: 0.9, Label: "Newsletter"}
+			if err := store.AppendDecision(decision); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendDecision(&MessageDecision{MessageID: "m2", Time: decision.Time, Source: SourceCache}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store = open()
+			defer store.Close()
+			loaded, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, records) {
+				t.Errorf("loaded records:\n%+v\nwant:\n%+v", loaded, records)
+			}
+
+			decisions, err := store.Decisions("m1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decisions) != 1 || !reflect.DeepEqual(decisions[0], decision) {
+				t.Errorf("decisions for m1 = %+v", decisions)
+			}
+			if all, err := store.Decisions(""); err != nil || len(all) != 2 {
+				t.Errorf("all decisions = %+v, %v", all, err)
+			}
+
+			if value, err := store.Meta("version"); err != nil || value != "1" {
+				t.Errorf("Meta(version) = %q, %v", value, err)
+			}
+			if value, err := store.Meta("unset"); err != nil || value != "" {
+				t.Errorf("Meta(unset) = %q, %v", value, err)
+			}
+
+			// Deleting a changed key removes it
+			delete(loaded, "deals@shop.example")
+			if err := store.Save(loaded, []string{"deals@shop.example"}); err != nil {
+				t.Fatal(err)
+			}
+			if again, err := store.Load(); err != nil || len(again) != 1 {
+				t.Errorf("records after delete = %+v, %v", again, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteMigratesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), sqliteFile)
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A database from before the meta table, decision log and engagement columns
+	if _, err := db.Exec(sqliteMigrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 1;
+		INSERT INTO senders (email, domain, status, seen_count) VALUES ('news@example.com', 'example.com', 'subscribed', 2)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { store.Close() }()
+
+	var version int
+	if err := store.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != len(sqliteMigrations) {
+		t.Errorf("user_version = %d, %v, want %d", version, err, len(sqliteMigrations))
+	}
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := records["news@example.com"]; record == nil || record.SeenCount != 2 || record.Engagement != nil {
+		t.Errorf("migrated record = %+v", record)
+	}
+	if err := store.SetMeta("version", "1"); err != nil {
+		t.Errorf("SetMeta after migration: %v", err)
+	}
+	if err := store.AppendDecision(&MessageDecision{MessageID: "m1", Time: time.Now()}); err != nil {
+		t.Errorf("AppendDecision after migration: %v", err)
+	}
+
+	// Opening an up-to-date database changes nothing
+	store.Close()
+	if store, err = OpenSQLiteStore(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteImportsJSONOnce(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, jsonFile)
+	dbPath := filepath.Join(dir, sqliteFile)
+
+	src := NewJSONStore(jsonPath)
+	if err := src.Save(testRecords(), []string{"deals@shop.example", "news@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A database created earlier without the import still gets it
+	empty, err := OpenSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty.Close()
+
+	tr, err := newSQLiteTracker(dbPath, jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(tr.records); got != 2 {
+		t.Fatalf("imported %d records, want 2", got)
+	}
+	tr.Close()
+
+	// Senders added to the JSON tracker later are not imported again
+	records := testRecords()
+	records["new@example.org"] = &EmailRecord{Email: "new@example.org", Status: StatusUnknown}
+	if err := src.Save(records, []string{"new@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	tr, err = newSQLiteTracker(dbPath, jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if got := len(tr.records); got != 2 {
+		t.Errorf("records after reopening = %d, want 2", got)
+	}
+}
+
+func TestStoreMergesConcurrentSaves(t *testing.T) {
+	for name, open := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			seed := open()
+			if err := seed.Save(testRecords(), []string{"deals@shop.example", "news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			seed.Close()
+
+			// Two runs load the same tracker
+			first, second := open(), open()
+			defer first.Close()
+			defer second.Close()
+			a, err := first.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := second.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Each sees the sender twice; the first unsubscribes from it and
+			// the second learns its new display name
+			a["news@example.com"].SeenCount += 2
+			a["news@example.com"].Status = StatusUnsubscribed
+			a["news@example.com"].Pinned = true
+			b["news@example.com"].SeenCount += 2
+			b["news@example.com"].Name = "The Weekly"
+			b["news@example.com"].Reevaluate = "not opened"
+			a["new@example.org"] = &EmailRecord{Email: "new@example.org", Status: StatusUnknown, SeenCount: 1}
+			b["new@example.org"] = &EmailRecord{Email: "new@example.org", Status: StatusUnknown, SeenCount: 1}
+
+			if err := first.Save(a, []string{"new@example.org", "news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := second.Save(b, []string{"new@example.org", "news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+
+			check := open()
+			defer check.Close()
+			records, err := check.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			news := records["news@example.com"]
+			if news.SeenCount != 7 || news.Name != "The Weekly" || news.Reevaluate != "not opened" || news.Status != StatusUnsubscribed || !news.Pinned {
+				t.Errorf("merged record = %+v, want 7 sightings and both changes kept", news)
+			}
+			if got := records["new@example.org"].SeenCount; got != 2 {
+				t.Errorf("sender new to both runs seen %d times, want 2", got)
+			}
+			// The saving process sees the merged values too
+			if got := b["news@example.com"]; got.SeenCount != 7 || got.Status != StatusUnsubscribed {
+				t.Errorf("in-memory record after save = %+v", got)
+			}
+
+			// A later save by the first run adds only its own new sightings
+			a["news@example.com"].SeenCount++
+			if err := first.Save(a, []string{"news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			if records, err = check.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if got := records["news@example.com"]; got.SeenCount != 8 || got.Name != "The Weekly" {
+				t.Errorf("record after second save = %+v", got)
+			}
+		})
+	}
+}
+
+func TestStoreParallelSavesKeepEverySighting(t *testing.T) {
+	for name, open := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			seed := open()
+			if err := seed.Save(testRecords(), []string{"deals@shop.example", "news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			seed.Close()
+
+			const runs = 4
+			errs := make(chan error, runs)
+			for i := 0; i < runs; i++ {
+				go func() {
+					store := open()
+					defer store.Close()
+					records, err := store.Load()
+					if err == nil {
+						records["news@example.com"].SeenCount++
+						err = store.Save(records, []string{"news@example.com"})
+					}
+					errs <- err
+				}()
+			}
+			for i := 0; i < runs; i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			check := open()
+			defer check.Close()
+			records, err := check.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := records["news@example.com"].SeenCount; got != 3+runs {
+				t.Errorf("seen count = %d, want %d", got, 3+runs)
+			}
+		})
+	}
+}
+
+func TestJSONStoreRotatesOncePerProcess(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, jsonFile)
+	records := testRecords()
+
+	for run := 1; run <= DefaultBackups+2; run++ {
+		store := NewJSONStore(path)
+		loaded, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run == 1 {
+			loaded = records
+		}
+		for save := 0; save < 3; save++ {
+			loaded["news@example.com"].SeenCount++
+			if err := store.Save(loaded, []string{"deals@shop.example", "news@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Each backup is the file as a run found it, three saves apart
+	for n := 1; n <= DefaultBackups; n++ {
+		data, err := os.ReadFile(backupPath(path, n))
+		if err != nil {
+			t.Fatalf("backup %d: %v", n, err)
+		}
+		var backup map[string]*EmailRecord
+		if err := json.Unmarshal(data, &backup); err != nil {
+			t.Fatalf("backup %d: %v", n, err)
+		}
+		want := 3 + 3*(DefaultBackups+2-n)
+		if got := backup["news@example.com"].SeenCount; got != want {
+			t.Errorf("backup %d has seen count %d, want %d", n, got, want)
+		}
+	}
+	if _, err := os.Stat(backupPath(path, DefaultBackups+1)); !os.IsNotExist(err) {
+		t.Errorf("kept more than %d backups", DefaultBackups)
+	}
+
+	// Saves leave no temporary files behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("tracker file mode = %v, %v", info, err)
+	}
+}
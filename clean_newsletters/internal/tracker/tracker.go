@@ -1,13 +1,18 @@
 package tracker
 
 import (
-	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"clean_newsletters/internal/mailaddr"
+	"golang.org/x/net/publicsuffix"
 )
 
 type EmailStatus string
@@ -16,106 +21,574 @@ const (
 	StatusSubscribed   EmailStatus = "subscribed"
 	StatusUnsubscribed EmailStatus = "unsubscribed"
 	StatusUnknown      EmailStatus = "unknown"
+	// StatusNotNewsletter is only ever set by the user during review.
+	StatusNotNewsletter EmailStatus = "not_newsletter"
+)
+
+const (
+	SourceLLM       = "llm"
+	SourceEmbedding = "embedding"
+	SourceBayes     = "bayes"
+	SourceUser      = "user"
+	// SourceCache marks messages decided by their sender's tracker status.
+	SourceCache = "cache"
 )
 
 type EmailRecord struct {
+	// Email is the bare, lowercase address the record is keyed by.
 	Email      string      `json:"email"`
+	Name       string      `json:"name,omitempty"`
 	Domain     string      `json:"domain"`
+	ListID     string      `json:"list_id,omitempty"`
 	Status     EmailStatus `json:"status"`
 	FirstSeen  time.Time   `json:"first_seen"`
 	LastSeen   time.Time   `json:"last_seen"`
 	SeenCount  int         `json:"seen_count"`
 	LastAction time.Time   `json:"last_action,omitempty"`
+	Decision   *Decision   `json:"decision,omitempty"`
+	// Pinned records were decided by the user and are never overwritten by
+	// the classifier.
+	Pinned bool `json:"pinned,omitempty"`
+	// Embedding is used to match new senders against subscribed ones.
+	Embedding *Embedding `json:"embedding,omitempty"`
+	// Engagement is how the sender's labeled messages were last treated.
+	Engagement *Engagement `json:"engagement,omitempty"`
+	// Reevaluate is why the sender's decision is being questioned again, or
+	// empty. It is cleared by the next fresh or user decision.
+	Reevaluate string `json:"reevaluate,omitempty"`
 }
 
+// Engagement counts a sender's labeled messages and what the user did with
+// them. Messages deleted for good count as deleted and unread.
+type Engagement struct {
+	Messages  int       `json:"messages"`
+	Unread    int       `json:"unread"`
+	Starred   int       `json:"starred,omitempty"`
+	Replied   int       `json:"replied,omitempty"`
+	Deleted   int       `json:"deleted,omitempty"`
+	CountedAt time.Time `json:"counted_at"`
+}
+
+// Opened is the number of counted messages the user opened.
+func (e *Engagement) Opened() int {
+	return e.Messages - e.Unread
+}
+
+// ReadRate is the share of counted messages the user opened.
+func (e *Engagement) ReadRate() float64 {
+	if e.Messages == 0 {
+		return 0
+	}
+	return float64(e.Opened()) / float64(e.Messages)
+}
+
+// Score ranks senders by engagement: each opened, starred or replied
+// message adds one and each deleted message subtracts one, averaged over
+// the counted messages. It ranges from -1 to 3.
+func (e *Engagement) Score() float64 {
+	if e.Messages == 0 {
+		return 0
+	}
+	return float64(e.Opened()+e.Starred+e.Replied-e.Deleted) / float64(e.Messages)
+}
+
+// Decision records why the classifier chose the current status.
+type Decision struct {
+	Source     string    `json:"source,omitempty"`
+	Category   string    `json:"category,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Reason     string    `json:"reason,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Prompt     string    `json:"prompt,omitempty"`
+	PromptHash string    `json:"prompt_hash,omitempty"`
+	DecidedAt  time.Time `json:"decided_at"`
+}
+
+// MessageDecision is one entry in the append-only log of what was decided
+// for each message, and why.
+type MessageDecision struct {
+	// MessageID is the mail provider's ID for the message.
+	MessageID  string    `json:"message_id"`
+	Sender     string    `json:"sender"`
+	Subject    string    `json:"subject"`
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	Model      string    `json:"model,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Reason     string    `json:"reason,omitempty"`
+	// Label is the label applied, empty if the message was left alone.
+	Label string `json:"label,omitempty"`
+}
+
+const (
+	jsonFile   = "newsletter_tracker.json"
+	sqliteFile = "newsletter_tracker.db"
+)
+
+// Versions of the stored data, kept in the store's metadata under
+// metaVersion. Each upgrade in Tracker.upgrade runs once per store.
+const (
+	metaVersion = "version"
+	// metaImported is when the JSON tracker was imported into the store.
+	metaImported = "imported_json"
+	// versionBareKeys keys records by bare lowercase address.
+	versionBareKeys = 1
+	dataVersion     = versionBareKeys
+)
+
 type Tracker struct {
-	mu       sync.RWMutex
-	records  map[string]*EmailRecord
-	filePath string
+	mu      sync.RWMutex
+	records map[string]*EmailRecord
+	store   Store
+	// groups indexes record keys by sender group (see groupKey).
+	groups map[string]map[string]bool
+	// dirty holds the keys changed since the last save.
+	dirty map[string]bool
+	// batches counts open Batch calls; saves wait until it drops to zero.
+	batches int
+	// lastDecisions indexes the newest logged decision by message ID. It is
+	// read from the decision log on first use.
+	lastDecisions map[string]*MessageDecision
 }
 
-func NewTracker(profile string) (*Tracker, error) {
+// NewTracker opens the profile's tracker using the named store (StoreJSON
+// or StoreSQLite).
+func NewTracker(profile, store string) (*Tracker, error) {
 	dir := fmt.Sprintf("%s/.config/clean_newsletters/%s", os.Getenv("HOME"), profile)
 	os.MkdirAll(dir, 0700)
 	
-	filePath := filepath.Join(dir, "newsletter_tracker.json")
-	
-	t := &Tracker{
-		records:  make(map[string]*EmailRecord),
-		filePath: filePath,
+	switch store {
+	case StoreJSON, "":
+		return NewFileTracker(filepath.Join(dir, jsonFile))
+	case StoreSQLite:
+		return newSQLiteTracker(filepath.Join(dir, sqliteFile), filepath.Join(dir, jsonFile))
+	default:
+		return nil, fmt.Errorf("unknown tracker store %q", store)
 	}
-	
-	if err := t.load(); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to load tracker data: %v", err)
+}
+
+// NewFileTracker keeps the tracker in the given JSON file instead of the
+// profile directory.
+func NewFileTracker(filePath string) (*Tracker, error) {
+	return New(NewJSONStore(filePath))
+}
+
+// newSQLiteTracker opens the database at path. A database that has not
+// been seeded yet is seeded from the JSON tracker at jsonPath, which is left
+// in place; the import is recorded in the database so it happens once.
+func newSQLiteTracker(path, jsonPath string) (*Tracker, error) {
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
+
+	store, err := OpenSQLiteStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := importOnce(store, NewJSONStore(jsonPath)); err != nil {
+		store.Close()
+		if created {
+			os.Remove(path)
+		}
+		return nil, fmt.Errorf("failed to import %s: %v", jsonPath, err)
+	}
+
+	t, err := New(store)
+	if err != nil {
+		store.Close()
+		return nil, err
 	}
-	
 	return t, nil
 }
 
-func (t *Tracker) load() error {
-	data, err := os.ReadFile(t.filePath)
+// importOnce copies src into dst unless dst records an earlier import.
+// A store that already has data of its own is never overwritten.
+func importOnce(dst, src Store) error {
+	imported, err := dst.Meta(metaImported)
+	if err != nil || imported != "" {
+		return err
+	}
+
+	records, err := dst.Load()
 	if err != nil {
 		return err
 	}
-	
-	return json.Unmarshal(data, &t.records)
+	decisions, err := dst.Decisions("")
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 && len(decisions) == 0 {
+		if err := importRecords(dst, src); err != nil {
+			return err
+		}
+	}
+
+	return dst.SetMeta(metaImported, time.Now().Format(time.RFC3339))
 }
 
-func (t *Tracker) save() error {
-	data, err := json.MarshalIndent(t.records, "", "  ")
+func importRecords(dst, src Store) error {
+	records, err := src.Load()
+	if err != nil {
+		return err
+	}
+	decisions, err := src.Decisions("")
 	if err != nil {
 		return err
 	}
+	if len(records) == 0 && len(decisions) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	if err := dst.Save(records, keys); err != nil {
+		return err
+	}
+	for _, decision := range decisions {
+		if err := dst.AppendDecision(decision); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Imported %d tracker records and %d message decisions into the database", len(records), len(decisions))
+	return nil
+}
+
+// New loads a tracker from store. The tracker owns the store and closes it
+// in Close.
+func New(store Store) (*Tracker, error) {
+	records, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tracker data: %v", err)
+	}
+
+	t := &Tracker{
+		records: records,
+		store:   store,
+		dirty:   make(map[string]bool),
+	}
+
+	if err := t.upgrade(); err != nil {
+		return nil, err
+	}
+	t.reindex()
 	
-	return os.WriteFile(t.filePath, data, 0600)
+	return t, nil
 }
 
-func (t *Tracker) RecordEmail(email string, status EmailStatus) error {
+// Close saves anything still pending and closes the store.
+func (t *Tracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.save()
+	if closeErr := t.store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Batch runs fn with saving deferred, then writes every change fn made in
+// one save, which the SQLite store commits as a single transaction.
+func (t *Tracker) Batch(fn func() error) error {
+	t.mu.Lock()
+	t.batches++
+	t.mu.Unlock()
+
+	err := fn()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batches--
+	if saveErr := t.save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// save writes the dirty records unless a batch is open. Callers hold mu.
+func (t *Tracker) save() error {
+	if t.batches > 0 || len(t.dirty) == 0 {
+		return nil
+	}
+
+	changed := make([]string, 0, len(t.dirty))
+	for key := range t.dirty {
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+
+	if err := t.store.Save(t.records, changed); err != nil {
+		return err
+	}
+	t.dirty = make(map[string]bool)
+	return nil
+}
+
+// RecordEmail updates the sender's status. listID is the message's List-Id,
+// if any. decision is nil when the status came from the tracker itself
+// rather than a fresh classification.
+func (t *Tracker) RecordEmail(email, listID string, status EmailStatus, decision *Decision) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	
-	key := strings.ToLower(email)
-	domain := extractDomain(email)
+	key, name := normalizeAddress(email)
+	domain := extractDomain(key)
+	t.dirty[key] = true
 	
 	if record, exists := t.records[key]; exists {
 		record.LastSeen = time.Now()
 		record.SeenCount++
+		if name != "" {
+			record.Name = name
+		}
+		t.setListID(key, record, listID)
+		if record.Pinned {
+			return t.save()
+		}
 		if record.Status != status {
 			record.Status = status
 			record.LastAction = time.Now()
 		}
+		if decision != nil {
+			record.Decision = decision
+			record.Reevaluate = ""
+		}
 	} else {
-		t.records[key] = &EmailRecord{
-			Email:     email,
+		record := &EmailRecord{
+			Email:     key,
+			Name:      name,
 			Domain:    domain,
+			ListID:    listID,
 			Status:    status,
 			FirstSeen: time.Now(),
 			LastSeen:  time.Now(),
 			SeenCount: 1,
+			Decision:  decision,
 		}
+		t.records[key] = record
+		t.index(key, record)
 	}
 	
 	return t.save()
 }
 
-func (t *Tracker) GetStatus(email string) EmailStatus {
+// PinStatus records a decision made by the user. Pinned statuses take
+// precedence over anything the classifier decides later.
+func (t *Tracker) PinStatus(email, listID string, status EmailStatus, decision *Decision) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key, name := normalizeAddress(email)
+	t.dirty[key] = true
+	record, exists := t.records[key]
+	if !exists {
+		record = &EmailRecord{
+			Email:     key,
+			Name:      name,
+			Domain:    extractDomain(key),
+			ListID:    listID,
+			FirstSeen: time.Now(),
+			LastSeen:  time.Now(),
+			SeenCount: 1,
+		}
+		t.records[key] = record
+		t.index(key, record)
+	} else {
+		t.setListID(key, record, listID)
+	}
+
+	if record.Status != status {
+		record.LastAction = time.Now()
+	}
+	record.Status = status
+	record.Decision = decision
+	record.Pinned = true
+	record.Reevaluate = ""
+
+	return t.save()
+}
+
+// GetStatus returns the sender's status, falling back to the status of
+// other senders in the same group: the same site (eTLD+1, so
+// mail.example.com and example.com match), or on shared newsletter
+// platforms the same mailing list.
+func (t *Tracker) GetStatus(email, listID string) EmailStatus {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	
-	key := strings.ToLower(email)
+	key, _ := normalizeAddress(email)
 	if record, exists := t.records[key]; exists {
 		return record.Status
 	}
 	
-	// Check if domain is known
-	domain := extractDomain(email)
-	for _, record := range t.records {
-		if record.Domain == domain && record.Status != StatusUnknown {
-			return record.Status
+	group := groupKey(extractDomain(key), listID)
+	if group == "" {
+		return StatusUnknown
+	}
+
+	// Prefer the user's decisions, then the most recent one
+	var best *EmailRecord
+	for member := range t.groups[group] {
+		record := t.records[member]
+		if record.Status == StatusUnknown || record.Status == StatusNotNewsletter {
+			continue
+		}
+		if best == nil || (record.Pinned && !best.Pinned) ||
+			(record.Pinned == best.Pinned && record.DecidedAt().After(best.DecidedAt())) {
+			best = record
 		}
 	}
+	if best == nil {
+		return StatusUnknown
+	}
 	
-	return StatusUnknown
+	return best.Status
+}
+
+// LogDecision appends an entry to the message decision log. Unlike sender
+// records it is written immediately, even inside a batch.
+func (t *Tracker) LogDecision(decision *MessageDecision) error {
+	if decision.Time.IsZero() {
+		decision.Time = time.Now()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.store.AppendDecision(decision); err != nil {
+		return err
+	}
+	if t.lastDecisions != nil {
+		t.lastDecisions[decision.MessageID] = decision
+	}
+	return nil
+}
+
+// LastDecision returns the newest logged decision for a message, or nil if
+// none was logged.
+func (t *Tracker) LastDecision(messageID string) (*MessageDecision, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lastDecisions == nil {
+		decisions, err := t.store.Decisions("")
+		if err != nil {
+			return nil, err
+		}
+		t.lastDecisions = make(map[string]*MessageDecision, len(decisions))
+		for _, decision := range decisions {
+			t.lastDecisions[decision.MessageID] = decision
+		}
+	}
+	return t.lastDecisions[messageID], nil
+}
+
+// Decisions returns the logged decisions for a message, oldest first, or
+// the whole log if messageID is empty.
+func (t *Tracker) Decisions(messageID string) ([]*MessageDecision, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.store.Decisions(messageID)
+}
+
+// GetEmbedding returns the stored vector for the sender if it was produced
+// by model.
+func (t *Tracker) GetEmbedding(email, model string) []float32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	key, _ := normalizeAddress(email)
+	record, exists := t.records[key]
+	if !exists || record.Embedding == nil || record.Embedding.Model != model {
+		return nil
+	}
+	return record.Embedding.Vector
+}
+
+// SetEmbeddings stores vectors for senders that already have a record and
+// ignores the rest.
+func (t *Tracker) SetEmbeddings(embeddings map[string]*Embedding) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := false
+	for email, embedding := range embeddings {
+		key, _ := normalizeAddress(email)
+		if record, exists := t.records[key]; exists {
+			record.Embedding = embedding
+			t.dirty[key] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return t.save()
+}
+
+// SetEngagement replaces the engagement counts of senders that have a
+// record and ignores the rest.
+func (t *Tracker) SetEngagement(engagement map[string]*Engagement) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for email, counts := range engagement {
+		key, _ := normalizeAddress(email)
+		if record, exists := t.records[key]; exists {
+			record.Engagement = counts
+			t.dirty[key] = true
+		}
+	}
+
+	return t.save()
+}
+
+// FlagForReevaluation marks the sender's decision as questioned for reason,
+// so its next message goes to the review queue.
+func (t *Tracker) FlagForReevaluation(email, reason string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key, _ := normalizeAddress(email)
+	record, exists := t.records[key]
+	if !exists || record.Reevaluate == reason {
+		return nil
+	}
+	record.Reevaluate = reason
+	t.dirty[key] = true
+
+	return t.save()
+}
+
+// ReevaluationReason returns why the sender is flagged for re-evaluation,
+// or "" if it is not.
+func (t *Tracker) ReevaluationReason(email string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	key, _ := normalizeAddress(email)
+	if record, exists := t.records[key]; exists {
+		return record.Reevaluate
+	}
+	return ""
+}
+
+// Records returns a copy of every record, sorted by address.
+func (t *Tracker) Records() []EmailRecord {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	records := make([]EmailRecord, 0, len(t.records))
+	for _, record := range t.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Email < records[j].Email })
+	return records
 }
 
 func (t *Tracker) GetSubscribedEmails() []string {
@@ -125,38 +598,246 @@ func (t *Tracker) GetSubscribedEmails() []string {
 	var subscribed []string
 	for _, record := range t.records {
 		if record.Status == StatusSubscribed {
-			subscribed = append(subscribed, record.Email)
+			subscribed = append(subscribed, record.sender())
 		}
 	}
 	
 	return subscribed
 }
 
-func (t *Tracker) GetStatistics() map[string]interface{} {
+// Statistics counts tracked senders by status.
+type Statistics struct {
+	Total         int `json:"total"`
+	Subscribed    int `json:"subscribed"`
+	Unsubscribed  int `json:"unsubscribed"`
+	NotNewsletter int `json:"not_newsletter"`
+	Unknown       int `json:"unknown"`
+}
+
+func (t *Tracker) Statistics() Statistics {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	
-	stats := map[string]interface{}{
-		"total_emails":      len(t.records),
-		"subscribed_count":  0,
-		"unsubscribed_count": 0,
-		"unknown_count":     0,
-	}
-	
+
+	stats := Statistics{Total: len(t.records)}
 	for _, record := range t.records {
 		switch record.Status {
 		case StatusSubscribed:
-			stats["subscribed_count"] = stats["subscribed_count"].(int) + 1
+			stats.Subscribed++
 		case StatusUnsubscribed:
-			stats["unsubscribed_count"] = stats["unsubscribed_count"].(int) + 1
+			stats.Unsubscribed++
+		case StatusNotNewsletter:
+			stats.NotNewsletter++
 		case StatusUnknown:
-			stats["unknown_count"] = stats["unknown_count"].(int) + 1
+			stats.Unknown++
 		}
 	}
-	
+
 	return stats
 }
 
+// sharedPlatforms are sites that send mail for many unrelated senders:
+// newsletter platforms and free mail providers. Their senders are grouped by
+// List-Id instead of by site, so unsubscribing from one Substack does not
+// condemn every other one.
+var sharedPlatforms = map[string]bool{
+	"substack.com":        true,
+	"beehiiv.com":         true,
+	"mcsv.net":            true, // Mailchimp
+	"mcdlv.net":           true,
+	"list-manage.com":     true,
+	"mailchimpapp.net":    true,
+	"ghost.io":            true,
+	"buttondown.email":    true,
+	"convertkit-mail.com": true,
+	"ck.page":             true,
+	"medium.com":          true,
+	"sendgrid.net":        true,
+	"gmail.com":           true,
+	"googlemail.com":      true,
+	"outlook.com":         true,
+	"hotmail.com":         true,
+	"yahoo.com":           true,
+}
+
+// groupKey returns the group a sender belongs to, or "" if it should only
+// ever match by exact address.
+func groupKey(domain, listID string) string {
+	if domain == "" {
+		return ""
+	}
+	site, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		site = domain
+	}
+	if !sharedPlatforms[site] {
+		return "site:" + site
+	}
+	if listID != "" {
+		return "list:" + listID
+	}
+	return ""
+}
+
+func (t *Tracker) reindex() {
+	t.groups = make(map[string]map[string]bool)
+	for key, record := range t.records {
+		t.index(key, record)
+	}
+}
+
+func (t *Tracker) index(key string, record *EmailRecord) {
+	group := groupKey(record.Domain, record.ListID)
+	if group == "" {
+		return
+	}
+	if t.groups[group] == nil {
+		t.groups[group] = make(map[string]bool)
+	}
+	t.groups[group][key] = true
+}
+
+// setListID records the latest List-Id seen for the sender and moves the
+// record to its new group if that changes.
+func (t *Tracker) setListID(key string, record *EmailRecord, listID string) {
+	if listID == "" || listID == record.ListID {
+		return
+	}
+	if group := groupKey(record.Domain, record.ListID); group != "" {
+		delete(t.groups[group], key)
+	}
+	record.ListID = listID
+	t.index(key, record)
+}
+
+// sender formats the record as a From value, keeping the display name
+// because it helps match other addresses of the same newsletter.
+func (r *EmailRecord) sender() string {
+	if r.Name == "" {
+		return r.Email
+	}
+	return fmt.Sprintf("%s <%s>", r.Name, r.Email)
+}
+
+// normalizeAddress returns the tracker key for a sender, its bare lowercase
+// address, and the display name if there was one.
+func normalizeAddress(from string) (key, name string) {
+	name, address := mailaddr.Parse(from)
+	return strings.ToLower(address), name
+}
+
+// upgrade applies the one-time changes to the stored data that it has not
+// had yet, recording the data version in the store's metadata.
+func (t *Tracker) upgrade() error {
+	value, err := t.store.Meta(metaVersion)
+	if err != nil {
+		return fmt.Errorf("failed to read tracker version: %v", err)
+	}
+	version, _ := strconv.Atoi(value)
+	if version >= dataVersion {
+		return nil
+	}
+
+	if version < versionBareKeys {
+		if merged := t.migrateKeys(); merged > 0 {
+			log.Printf("Normalized %d tracker records to bare email addresses", merged)
+			if err := t.save(); err != nil {
+				return fmt.Errorf("failed to save migrated tracker data: %v", err)
+			}
+		}
+	}
+
+	if err := t.store.SetMeta(metaVersion, strconv.Itoa(dataVersion)); err != nil {
+		return fmt.Errorf("failed to record tracker version: %v", err)
+	}
+	return nil
+}
+
+// migrateKeys re-keys records created before keys were normalized, when the
+// whole From value (display name included) was used as the key. Records
+// that turn out to be the same address are merged. It returns the number
+// of records changed.
+func (t *Tracker) migrateKeys() int {
+	keys := make([]string, 0, len(t.records))
+	for key := range t.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changed := 0
+	for _, key := range keys {
+		record := t.records[key]
+		normalized, name := normalizeAddress(key)
+		if address, original := normalizeAddress(record.Email); address == normalized && original != "" {
+			name = original
+		}
+		if normalized == "" || (normalized == key && record.Email == key && record.Domain == extractDomain(key)) {
+			continue
+		}
+
+		delete(t.records, key)
+		t.dirty[key] = true
+		t.dirty[normalized] = true
+		record.Email = normalized
+		record.Domain = extractDomain(normalized)
+		if record.Name == "" {
+			record.Name = name
+		}
+		if existing, exists := t.records[normalized]; exists {
+			mergeRecords(existing, record)
+		} else {
+			t.records[normalized] = record
+		}
+		changed++
+	}
+
+	return changed
+}
+
+// mergeRecords folds src into dst. Counts and dates are combined; the
+// status comes from the pinned record, or else the more recent decision.
+func mergeRecords(dst, src *EmailRecord) {
+	if dst.FirstSeen.IsZero() || (!src.FirstSeen.IsZero() && src.FirstSeen.Before(dst.FirstSeen)) {
+		dst.FirstSeen = src.FirstSeen
+	}
+	if src.LastSeen.After(dst.LastSeen) {
+		dst.LastSeen = src.LastSeen
+	}
+	if src.LastAction.After(dst.LastAction) {
+		dst.LastAction = src.LastAction
+	}
+	dst.SeenCount += src.SeenCount
+
+	if (src.Pinned && !dst.Pinned) || (src.Pinned == dst.Pinned && src.DecidedAt().After(dst.DecidedAt())) {
+		dst.Status = src.Status
+		dst.Decision = src.Decision
+		dst.Pinned = src.Pinned
+	}
+
+	if dst.Name == "" {
+		dst.Name = src.Name
+	}
+	if dst.ListID == "" {
+		dst.ListID = src.ListID
+	}
+	if dst.Embedding == nil {
+		dst.Embedding = src.Embedding
+	}
+	if dst.Engagement == nil {
+		dst.Engagement = src.Engagement
+	}
+}
+
+// DecidedAt is when the sender's current status was decided.
+func (r *EmailRecord) DecidedAt() time.Time {
+	if r.Decision != nil {
+		return r.Decision.DecidedAt
+	}
+	if !r.LastAction.IsZero() {
+		return r.LastAction
+	}
+	return r.FirstSeen
+}
+
 func extractDomain(email string) string {
 	parts := strings.Split(strings.ToLower(email), "@")
 	if len(parts) >= 2 {
@@ -0,0 +1,161 @@
+package tracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateKeysOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), jsonFile)
+	legacy := `{
+		"Weekly News <News@Example.com>": {"email": "Weekly News <News@Example.com>", "status": "subscribed", "seen_count": 2},
+		"news@example.com": {"email": "news@example.com", "status": "subscribed", "seen_count": 3}
+	}`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewFileTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := tr.records["news@example.com"]
+	if len(tr.records) != 1 || record == nil || record.Name != "Weekly News" || record.SeenCount != 5 {
+		t.Fatalf("records after migration = %+v", tr.records)
+	}
+	if got := tr.GetStatus("Anyone <NEWS@example.com>", ""); got != StatusSubscribed {
+		t.Errorf("status by another From value = %s, want subscribed", got)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := NewJSONStore(path).Meta(metaVersion); err != nil || version != "1" {
+		t.Errorf("stored version = %q, %v", version, err)
+	}
+
+	// A migrated store is not scanned again
+	store := NewJSONStore(path)
+	recordsByKey, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordsByKey["Other <Other@Example.org>"] = &EmailRecord{Email: "Other <Other@Example.org>", Status: StatusUnknown}
+	if err := store.Save(recordsByKey, []string{"Other <Other@Example.org>"}); err != nil {
+		t.Fatal(err)
+	}
+	tr, err = New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if len(tr.records) != 2 {
+		t.Errorf("records on second load = %+v, want the unmigrated key kept", tr.records)
+	}
+}
+
+func TestGetStatusGroups(t *testing.T) {
+	older := &Decision{DecidedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	newer := &Decision{DecidedAt: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)}
+
+	type sender struct {
+		email, listID string
+		status        EmailStatus
+		decision      *Decision
+		pinned        bool
+	}
+	tests := []struct {
+		name    string
+		senders []sender
+		email   string
+		listID  string
+		want    EmailStatus
+	}{
+		{
+			name:    "subdomain inherits the site's status",
+			senders: []sender{{email: "news@example.com", status: StatusSubscribed}},
+			email:   "offers@mail.example.com",
+			want:    StatusSubscribed,
+		},
+		{
+			name:    "site is the registrable domain under a public suffix",
+			senders: []sender{{email: "news@mail.example.co.uk", status: StatusUnsubscribed}},
+			email:   "deals@example.co.uk",
+			want:    StatusUnsubscribed,
+		},
+		{
+			name:    "other sites under the same public suffix are not grouped",
+			senders: []sender{{email: "news@example.co.uk", status: StatusUnsubscribed}},
+			email:   "news@other.co.uk",
+			want:    StatusUnknown,
+		},
+		{
+			name:    "shared platform without List-Id is not grouped",
+			senders: []sender{{email: "author@substack.com", listID: "author.substack.com", status: StatusUnsubscribed}},
+			email:   "writer@substack.com",
+			want:    StatusUnknown,
+		},
+		{
+			name:    "shared platform with a different List-Id is not grouped",
+			senders: []sender{{email: "author@substack.com", listID: "author.substack.com", status: StatusUnsubscribed}},
+			email:   "writer@substack.com",
+			listID:  "writer.substack.com",
+			want:    StatusUnknown,
+		},
+		{
+			name:    "shared platform groups by List-Id",
+			senders: []sender{{email: "author@substack.com", listID: "author.substack.com", status: StatusUnsubscribed}},
+			email:   "no-reply@substack.com",
+			listID:  "author.substack.com",
+			want:    StatusUnsubscribed,
+		},
+		{
+			name: "newer decision wins",
+			senders: []sender{
+				{email: "a@example.com", status: StatusUnsubscribed, decision: older},
+				{email: "b@example.com", status: StatusSubscribed, decision: newer},
+			},
+			email: "c@example.com",
+			want:  StatusSubscribed,
+		},
+		{
+			name: "pinned decision beats a newer one",
+			senders: []sender{
+				{email: "a@example.com", status: StatusUnsubscribed, decision: older, pinned: true},
+				{email: "b@example.com", status: StatusSubscribed, decision: newer},
+			},
+			email: "c@example.com",
+			want:  StatusUnsubscribed,
+		},
+		{
+			name: "not-newsletter senders do not decide the group",
+			senders: []sender{
+				{email: "a@example.com", status: StatusSubscribed, decision: older},
+				{email: "b@example.com", status: StatusNotNewsletter, decision: newer, pinned: true},
+			},
+			email: "c@example.com",
+			want:  StatusSubscribed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewFileTracker(filepath.Join(t.TempDir(), "newsletter_tracker.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.senders {
+				record := tr.RecordEmail
+				if s.pinned {
+					record = tr.PinStatus
+				}
+				if err := record(s.email, s.listID, s.status, s.decision); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := tr.GetStatus(tt.email, tt.listID); got != tt.want {
+				t.Errorf("GetStatus(%q, %q) = %s, want %s", tt.email, tt.listID, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,34 +2,306 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
 
 	"clean_newsletters/internal/auth"
 	"clean_newsletters/internal/config"
 	"clean_newsletters/internal/email"
+	"clean_newsletters/internal/llm"
 	"clean_newsletters/internal/newsletter"
+	"clean_newsletters/internal/tracker"
 )
 
 func main() {
 	ctx := context.Background()
 
+	command := "run"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var err error
+	switch command {
+	case "run", "review", "reevaluate":
+		err = runInbox(ctx, command)
+	case "eval":
+		runEval(ctx, os.Args[2:])
+	case "train":
+		runTrain()
+	case "history":
+		runHistory(os.Args[2:])
+	case "senders":
+		if len(os.Args) < 3 || os.Args[2] != "report" {
+			fmt.Fprintf(os.Stderr, "Usage: %s senders report\n", os.Args[0])
+			os.Exit(2)
+		}
+		err = runInbox(ctx, "senders report")
+	default:
+		log.Fatalf("Unknown command %q (expected run, review, reevaluate, senders, eval, train or history)", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runInbox runs a command against the mailbox. Errors are returned rather
+// than fatal so the mail provider and processor are closed on the way out.
+func runInbox(ctx context.Context, command string) error {
 	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	emailClient, err := newMailProvider(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail provider: %v", err)
+	}
+	if closer, ok := emailClient.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	llmClient, err := newsletter.NewLLMClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create LLM client: %v", err)
+	}
+	t, remove, err := newTracker(cfg)
+	if err != nil {
+		if closer, ok := llmClient.(io.Closer); ok {
+			closer.Close()
+		}
+		return fmt.Errorf("failed to create tracker: %v", err)
+	}
+	defer remove()
+
+	newsletterProcessor, err := newsletter.NewProcessor(cfg, emailClient, llmClient, t)
+	if err != nil {
+		return fmt.Errorf("failed to create processor: %v", err)
+	}
+	defer func() {
+		if err := newsletterProcessor.Close(); err != nil {
+			log.Printf("Failed to close processor: %v", err)
+		}
+	}()
+
+	switch command {
+	case "review":
+		if err := newsletterProcessor.Review(ctx, os.Stdin, os.Stdout); err != nil {
+			return fmt.Errorf("failed to review emails: %v", err)
+		}
+		return nil
+	case "reevaluate":
+		flagged, err := newsletterProcessor.Reevaluate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to re-evaluate senders: %v", err)
+		}
+		for _, r := range flagged {
+			fmt.Printf("%s (%s): %s\n", r.Sender, r.Status, r.Reason)
+		}
+		fmt.Printf("%d senders flagged; their next emails go to the review queue\n", len(flagged))
+		return nil
+	case "senders report":
+		report, err := newsletterProcessor.SendersReport(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to report senders: %v", err)
+		}
+		report.Write(os.Stdout)
+		return nil
+	}
+
+	// A JSON report on standard output must be the only thing there
+	progress := os.Stdout
+	if cfg.ReportFormat == newsletter.FormatJSON && cfg.ReportFile == "" {
+		progress = os.Stderr
+	}
+	newsletterProcessor.SetProgress(progress)
+
+	report, err := newsletterProcessor.ProcessInbox(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to process inbox: %v", err)
+	}
+	if err := writeReport(report, cfg); err != nil {
+		return fmt.Errorf("failed to write run report: %v", err)
+	}
+
+	fmt.Fprintln(progress, "Newsletter processing completed successfully")
+	return nil
+}
+
+// newTracker opens the profile's tracker. Archive runs get a temporary one
+// instead, so back-testing an export never changes the sender statuses that
+// live runs rely on; remove deletes it again.
+func newTracker(cfg *config.Config) (t *tracker.Tracker, remove func(), err error) {
+	if !cfg.IsArchive() {
+		t, err = tracker.NewTracker(cfg.AccountProfile, cfg.TrackerStore)
+		return t, func() {}, err
+	}
+
+	dir, err := os.MkdirTemp("", "clean_newsletters_archive")
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err = tracker.NewFileTracker(filepath.Join(dir, "newsletter_tracker.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	return t, func() { os.RemoveAll(dir) }, nil
+}
+
+// writeReport prints the run report, or writes it to REPORT_FILE when set.
+func writeReport(report *newsletter.RunReport, cfg *config.Config) error {
+	if cfg.ReportFile == "" {
+		return report.Render(os.Stdout, cfg.ReportFormat)
+	}
+
+	f, err := os.Create(cfg.ReportFile)
+	if err != nil {
+		return err
+	}
+	if err := report.Render(f, cfg.ReportFormat); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runEval(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	replay := flags.String("replay", "", "answer LLM requests only from this cassette, failing on anything unrecorded")
+	record := flags.String("record", "", "send every LLM request to the model and record it in this cassette")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: clean_newsletters eval [-replay file | -record file] dataset.jsonl|dataset.mbox\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || (*replay != "" && *record != "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadClassifier()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	switch {
+	case *replay != "":
+		cfg.LLMCassette, cfg.LLMCassetteMode = *replay, llm.CassetteStrict
+	case *record != "":
+		cfg.LLMCassette, cfg.LLMCassetteMode = *record, llm.CassetteRecord
+	}
+	if err := cfg.CheckAPIKey(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
 
-	authClient, err := auth.NewGmailAuth(ctx, cfg)
+	dataset, err := newsletter.LoadDataset(flags.Arg(0))
 	if err != nil {
-		log.Fatalf("Failed to authenticate with Gmail: %v", err)
+		log.Fatalf("Failed to load dataset: %v", err)
 	}
 
-	emailClient := email.NewGmailClient(authClient)
-	newsletterProcessor := newsletter.NewProcessor(cfg, emailClient)
+	llmClient, err := newsletter.NewLLMClient(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create LLM client: %v", err)
+	}
+	if closer, ok := llmClient.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-	if err := newsletterProcessor.ProcessInbox(ctx); err != nil {
-		log.Fatalf("Failed to process inbox: %v", err)
+	// Cassettes only replay runs made with the same prompts, so recorded
+	// and replayed evaluations leave the profile's customisations out
+	opts := newsletter.EvalOptions{Builtin: cfg.LLMCassette != ""}
+	report, err := newsletter.Evaluate(ctx, cfg, dataset, llmClient, opts)
+	if err != nil {
+		log.Fatalf("Failed to evaluate: %v", err)
 	}
 
-	fmt.Println("Newsletter processing completed successfully")
-}
\ No newline at end of file
+	fmt.Println()
+	report.Write(os.Stdout)
+}
+
+func runTrain() {
+	cfg, err := config.LoadClassifier()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	result, err := newsletter.Train(cfg)
+	if err != nil {
+		log.Fatalf("Failed to train local classifier: %v", err)
+	}
+
+	fmt.Printf("Trained local classifier on %d emails (%d newsletters, %d other, %d features)\n",
+		result.Examples, result.Newsletters, result.Other, result.Vocabulary)
+	fmt.Printf("Saved to %s\n", result.Path)
+}
+
+func runHistory(messageIDs []string) {
+	if len(messageIDs) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: clean_newsletters history message-id...\n")
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadTracker()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	t, err := tracker.NewTracker(cfg.AccountProfile, cfg.TrackerStore)
+	if err != nil {
+		log.Fatalf("Failed to open tracker: %v", err)
+	}
+	defer t.Close()
+
+	for _, id := range messageIDs {
+		decisions, err := t.Decisions(id)
+		if err != nil {
+			log.Fatalf("Failed to read decision log: %v", err)
+		}
+		if len(decisions) == 0 {
+			fmt.Printf("%s: no decisions logged\n", id)
+			continue
+		}
+
+		fmt.Printf("%s: %q from %s\n", id, decisions[0].Subject, decisions[0].Sender)
+		for _, d := range decisions {
+			label := d.Label
+			if label == "" {
+				label = "(none)"
+			}
+			fmt.Printf("  %s  %-9s %-20s %.2f  %-18s %s\n", d.Time.Format("2006-01-02 15:04"), d.Source, d.Model, d.Confidence, label, d.Reason)
+		}
+	}
+}
+
+func newMailProvider(ctx context.Context, cfg *config.Config) (email.MailProvider, error) {
+	switch cfg.MailProvider {
+	case config.ProviderIMAP:
+		return email.NewIMAPClient(cfg)
+	case config.ProviderMbox, config.ProviderMaildir:
+		report, err := email.NewDecisionReport(cfg.ReportPath)
+		if err != nil {
+			return nil, err
+		}
+		var archive *email.ArchiveClient
+		if cfg.MailProvider == config.ProviderMbox {
+			archive, err = email.NewMboxClient(cfg.ArchivePath, report)
+		} else {
+			archive, err = email.NewMaildirClient(cfg.ArchivePath, report)
+		}
+		if err != nil {
+			report.Close()
+			return nil, err
+		}
+		return archive, nil
+	default:
+		authClient, err := auth.NewGmailAuth(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate with Gmail: %v", err)
+		}
+		return email.NewGmailClient(authClient), nil
+	}
+}